@@ -0,0 +1,61 @@
+package govm
+
+import (
+	"./codegen"
+	"./types"
+	"testing"
+)
+
+func TestArray(t *testing.T) {
+	g := codegen.New()
+	g.Push(2)
+	g.Make(codegen.Typ("[]int"))
+	g.Push(7)
+	g.Append()
+	g.Dup()
+	g.Push(0)
+	g.Push(5)
+	g.Store()
+	g.Push(1)
+	g.Push(3)
+	g.Slice()
+	g.Set("arr")
+
+	code, err := g.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := New()
+	if err := v.Load(code); err != nil {
+		t.Fatal("Load:", err)
+	}
+
+	if err := v.Get("arr"); err != nil {
+		t.Fatal(err)
+	}
+	v.Dup()
+	if err := v.Len(); err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := v.Pop(); n != 2 {
+		t.Error("Expected length 2, got", n)
+	}
+	v.Push(1)
+	if err := v.Index(); err != nil {
+		t.Fatal(err)
+	}
+	if x, _ := v.Pop(); x != 7 {
+		t.Error("Expected element 1 to be 7, got", x)
+	}
+
+	v.Get("arr")
+	v.Push("seven")
+	if _, ok := v.Append().(types.TypeError); !ok {
+		t.Error("Expected type error from append")
+	}
+	v.Get("arr")
+	v.Push(2)
+	if _, ok := v.Index().(types.IndexError); !ok {
+		t.Error("Expected index error from index")
+	}
+}
@@ -0,0 +1,574 @@
+// Package asm converts between GVA, govm's assembly-like IR, and GVB
+package asm
+
+import (
+	"io"
+	"bufio"
+	"strings"
+	"strconv"
+	"../bytecode"
+	"../codegen"
+	"../types"
+)
+
+type Converter struct {
+	in *bufio.Scanner
+	gen codegen.Generator
+	labels map[string]*int
+	structs codegen.Names
+	locals []*slots // Local variable slots of each function being converted, innermost last
+	tok *tokenizer // Positions of tokens, if debug info is being generated
+}
+
+// slots are the local variable slots of a function being converted
+type slots struct {
+	names map[string]int
+	n int // Number of slots used so far
+	count int // Number of slots given by a locals directive, or -1 to use n
+}
+
+type InvalidOpcodeError struct { opcode string }
+
+func (e InvalidOpcodeError) Error() string {
+	return "Invalid opcode: " + e.opcode
+}
+
+type LocalError struct { name string }
+
+func (e LocalError) Error() string {
+	return "Local variable outside function: " + e.name
+}
+
+// LocalsError is returned when a locals directive gives a negative number of
+// slots, more than bytecode.MaxLocals, or fewer than the function uses
+type LocalsError struct { n, used int }
+
+func (e LocalsError) Error() string {
+	s := "Invalid number of locals: " + strconv.Itoa(e.n)
+	if e.n >= 0 && e.n <= bytecode.MaxLocals {
+		s += ", but the function uses " + strconv.Itoa(e.used)
+	}
+	return s
+}
+
+type UnknownTokenError struct { tok string }
+
+func (e UnknownTokenError) Error() string {
+	return "Unknown token: '" + e.tok + "'"
+}
+
+func sep(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n'
+}
+
+func scanToken(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	for ; advance < len(data) && sep(data[advance]); advance++ {}
+	if advance >= len(data) {
+		return advance, nil, nil
+	}
+	start := advance
+
+	if data[advance] == '"' {
+		closed := false
+stringLoop:
+		for advance < len(data) - 1 {
+			advance++
+			switch data[advance] {
+			case '"':
+				advance++
+				closed = true
+				break stringLoop
+			case '\\':
+				advance++
+			}
+		}
+		if !closed {
+			if !atEOF {
+				// Request more data
+				return start, nil, nil
+			}
+			advance = len(data)
+		}
+		token = data[start:advance]
+		return
+	}
+
+	for ; advance < len(data) && !sep(data[advance]); advance++ {}
+	if advance == len(data) && !atEOF {
+		return start, nil, nil
+	}
+	token = data[start:advance]
+	if len(token) == 0 {
+		token = nil
+	}
+	return
+}
+
+// tokenizer splits GVA into tokens like scanToken, keeping track of the
+// position of the last token
+type tokenizer struct {
+	pos  types.Pos // Position of the last token
+	next types.Pos // Position of the next byte
+}
+
+func newTokenizer(file string) *tokenizer {
+	return &tokenizer{types.Pos{file, 1, 1}, types.Pos{file, 1, 1}}
+}
+
+func (t *tokenizer) split(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	advance, token, err = scanToken(data, atEOF)
+	if token == nil {
+		t.skip(data[:advance])
+		return
+	}
+	t.skip(data[:advance-len(token)])
+	t.pos = t.next
+	t.skip(token)
+	return
+}
+
+func (t *tokenizer) skip(data []byte) {
+	for _, c := range data {
+		if c == '\n' {
+			t.next.Line++
+			t.next.Col = 1
+		} else {
+			t.next.Col++
+		}
+	}
+}
+
+func readOperands(in *bufio.Scanner, n int) ([]string, error) {
+	values := make([]string, n)
+	for i := 0; i < n; i++ {
+		if !in.Scan() {
+			if err := in.Err(); err == nil {
+				return values, io.ErrUnexpectedEOF
+			} else {
+				return values, err
+			}
+		}
+		values[i] = in.Text()
+	}
+	return values, nil
+}
+
+func readOperand(in *bufio.Scanner) (string, error) {
+	values, err := readOperands(in, 1)
+	return values[0], err
+}
+
+func readValue(in *bufio.Scanner) (types.Value, error) {
+	val, err := readOperand(in)
+	if err != nil {
+		return nil, err
+	}
+	if len(val) == 0 {
+		return nil, UnknownTokenError{val}
+	}
+
+	if val[0] == '"' {
+		if len(val) < 2 || val[len(val)-1] != '"' {
+			return nil, UnknownTokenError{val}
+		}
+		// Escape sequences are interpreted as in Go, but strings that aren't
+		// valid Go string literals are used as they are
+		if s, err := strconv.Unquote(val); err == nil {
+			return s, nil
+		}
+		return val[1:len(val)-1], nil
+	} else if val == "true" || val == "false" {
+		return val == "true", nil
+	} else if i, err := strconv.Atoi(val); err == nil {
+		return i, nil
+	} else if f, err := strconv.ParseFloat(val, 64); err == nil {
+		return f, nil
+	}
+	return nil, UnknownTokenError{val}
+}
+
+func readInt(in *bufio.Scanner) (int, error) {
+	val, err := readOperand(in)
+	if err != nil {
+		return 0, err
+	}
+	i, err := strconv.Atoi(val)
+	if err != nil {
+		return 0, UnknownTokenError{val}
+	}
+	return i, nil
+}
+
+func readSym(in *bufio.Scanner) (string, error) {
+	val, err := readOperand(in)
+	if err != nil {
+		return "", err
+	}
+	if len(val) == 0 || val[0] != '@' {
+		return "", UnknownTokenError{val}
+	}
+	return val[1:], nil
+}
+
+func (c *Converter) convertInstruction(opcode string) error {
+	opcode = strings.ToLower(opcode)
+	switch opcode {
+	case "j":
+		lbl, err := readOperand(c.in)
+		if err != nil {
+			return err
+		}
+		if c.labels[lbl] == nil {
+			c.labels[lbl] = new(int)
+		}
+		c.gen.J(c.labels[lbl])
+
+	case "jt":
+		lbl, err := readOperand(c.in)
+		if err != nil {
+			return err
+		}
+		if c.labels[lbl] == nil {
+			c.labels[lbl] = new(int)
+		}
+		c.gen.JT(c.labels[lbl])
+
+	case "jf":
+		lbl, err := readOperand(c.in)
+		if err != nil {
+			return err
+		}
+		if c.labels[lbl] == nil {
+			c.labels[lbl] = new(int)
+		}
+		c.gen.JF(c.labels[lbl])
+
+	case "jz":
+		lbl, err := readOperand(c.in)
+		if err != nil {
+			return err
+		}
+		if c.labels[lbl] == nil {
+			c.labels[lbl] = new(int)
+		}
+		c.gen.JZ(c.labels[lbl])
+
+	case "jnz":
+		lbl, err := readOperand(c.in)
+		if err != nil {
+			return err
+		}
+		if c.labels[lbl] == nil {
+			c.labels[lbl] = new(int)
+		}
+		c.gen.JNz(c.labels[lbl])
+
+	case "push":
+		value, err := readValue(c.in)
+		if err != nil {
+			return err
+		}
+		c.gen.Push(value)
+
+	case "pop":
+		c.gen.Pop()
+	case "dup":
+		c.gen.Dup()
+	case "swp":
+		c.gen.Swp()
+	case "set":
+		value, err := readSym(c.in)
+		if err != nil {
+			return err
+		}
+		c.gen.Set(value)
+	case "get":
+		value, err := readSym(c.in)
+		if err != nil {
+			return err
+		}
+		c.gen.Get(value)
+	case "lset", "lget":
+		name, err := readOperand(c.in)
+		if err != nil {
+			return err
+		}
+		n, err := c.local(name)
+		if err != nil {
+			return err
+		}
+		if opcode == "lset" {
+			c.gen.LSet(n)
+		} else {
+			c.gen.LGet(n)
+		}
+
+	case "inc":
+		c.gen.Inc()
+	case "dec":
+		c.gen.Dec()
+	case "add":
+		c.gen.Add()
+	case "sub":
+		c.gen.Sub()
+	case "mul":
+		c.gen.Mul()
+	case "div":
+		c.gen.Div()
+	case "mod":
+		c.gen.Mod()
+
+	case "eq":
+		c.gen.EQ()
+	case "ne":
+		c.gen.NE()
+	case "lt":
+		c.gen.LT()
+	case "gt":
+		c.gen.GT()
+	case "le":
+		c.gen.LE()
+	case "ge":
+		c.gen.GE()
+
+	case "and":
+		c.gen.And()
+	case "or":
+		c.gen.Or()
+	case "xor":
+		c.gen.Xor()
+	case "not":
+		c.gen.Not()
+
+	case "band":
+		c.gen.BAnd()
+	case "bor":
+		c.gen.BOr()
+	case "bxor":
+		c.gen.BXor()
+	case "bnot":
+		c.gen.BNot()
+	case "bls":
+		c.gen.BLS()
+	case "brs":
+		c.gen.BRS()
+
+	case "bset":
+		c.gen.BSet()
+	case "bclr":
+		c.gen.BClr()
+	case "btgl":
+		c.gen.BTgl()
+	case "bmtch":
+		c.gen.BMtch()
+
+	case "call":
+		c.gen.Call()
+	case "ret":
+		c.gen.Ret()
+
+	case "new":
+		name, err := readOperand(c.in)
+		if err != nil {
+			return err
+		}
+		i, ok := c.structs[name]
+		if !ok {
+			if i, err = strconv.Atoi(name); err != nil {
+				return UnknownTokenError{name}
+			}
+		}
+		c.gen.New(i)
+	case "fget":
+		n, err := readInt(c.in)
+		if err != nil {
+			return err
+		}
+		c.gen.FGet(n)
+	case "fset":
+		n, err := readInt(c.in)
+		if err != nil {
+			return err
+		}
+		c.gen.FSet(n)
+
+	case "make":
+		t, err := readOperand(c.in)
+		if err != nil {
+			return err
+		}
+		c.gen.Make(c.structs.Typ(t))
+	case "index":
+		c.gen.Index()
+	case "store":
+		c.gen.Store()
+	case "len":
+		c.gen.Len()
+	case "append":
+		c.gen.Append()
+	case "slice":
+		c.gen.Slice()
+
+	case "lookup":
+		c.gen.Lookup()
+	case "delete":
+		c.gen.Delete()
+	case "keys":
+		c.gen.Keys()
+	case "skeys":
+		c.gen.SKeys()
+
+	case "throw":
+		c.gen.Throw()
+	case "try":
+		lbl, err := readOperand(c.in)
+		if err != nil {
+			return err
+		}
+		if c.labels[lbl] == nil {
+			c.labels[lbl] = new(int)
+		}
+		c.gen.Try(c.labels[lbl])
+	case "endtry":
+		c.gen.EndTry()
+
+	// Special cases
+	case "func":
+		sig, err := readOperand(c.in)
+		if err != nil {
+			return err
+		}
+		return c.parseFunction(c.structs.Sig(sig))
+
+	case "struct":
+		ops, err := readOperands(c.in, 2)
+		if err != nil {
+			return err
+		}
+		if ops[0] == "_" {
+			c.gen.Struct("", c.structs.Types(ops[1]))
+		} else {
+			c.structs[ops[0]] = c.gen.Struct(ops[0], c.structs.Types(ops[1]))
+		}
+
+	case "//":
+		_, err := readOperand(c.in)
+		return err
+
+	case ".":
+		lbl, err := readOperand(c.in)
+		if err != nil {
+			return err
+		}
+		c.labels[lbl] = c.gen.Label(c.labels[lbl])
+
+	case "locals":
+		n, err := readInt(c.in)
+		if err != nil {
+			return err
+		}
+		if len(c.locals) == 0 {
+			return LocalError{"locals " + strconv.Itoa(n)}
+		}
+		if n < 0 || n > bytecode.MaxLocals {
+			return LocalsError{n, 0}
+		}
+		c.locals[len(c.locals)-1].count = n
+
+	default:
+		return InvalidOpcodeError{opcode}
+	}
+	return nil
+}
+
+func (c *Converter) parseToplevel() error {
+	for c.in.Scan() {
+		opcode := c.in.Text()
+		if c.tok != nil {
+			c.gen.Pos(c.tok.pos)
+		}
+		if err := c.convertInstruction(opcode); err != nil {
+			return err
+		}
+	}
+	return c.in.Err()
+}
+
+// local returns the slot of a local variable in the innermost function. name
+// is either a slot number, or a name, which is given the next unused slot
+// the first time it is used.
+func (c *Converter) local(name string) (int, error) {
+	if len(c.locals) == 0 {
+		return 0, LocalError{name}
+	}
+	s := c.locals[len(c.locals)-1]
+	if n, err := strconv.Atoi(name); err == nil && n >= 0 {
+		if n >= s.n {
+			s.n = n + 1
+		}
+		return n, nil
+	}
+	n, ok := s.names[name]
+	if !ok {
+		n = s.n
+		s.names[name] = n
+		s.n++
+	}
+	return n, nil
+}
+
+func (c *Converter) parseFunction(sig types.TypeSignature) error {
+	endLbl := new(int)
+	locals := c.gen.Func(sig, endLbl)
+	c.locals = append(c.locals, &slots{make(map[string]int), 0, -1})
+	defer func() {
+		c.locals = c.locals[:len(c.locals)-1]
+	}()
+	for c.in.Scan() {
+		opcode := c.in.Text()
+		if c.tok != nil {
+			c.gen.Pos(c.tok.pos)
+		}
+		if opcode == "endfunc" {
+			c.gen.Label(endLbl)
+			s := c.locals[len(c.locals)-1]
+			locals.N = s.n
+			if s.count >= 0 {
+				if s.count < s.n {
+					return LocalsError{s.count, s.n}
+				}
+				locals.N = s.count
+			}
+			return nil
+		}
+		if err := c.convertInstruction(opcode); err != nil {
+			return err
+		}
+	}
+	if err := c.in.Err(); err == nil {
+		return io.ErrUnexpectedEOF
+	} else {
+		return err
+	}
+}
+
+// Assemble converts GVA read from r into a code generator, from which GVB can
+// be generated
+func Assemble(r io.Reader) (codegen.Generator, error) {
+	in := bufio.NewScanner(r)
+	in.Split(scanToken)
+	c := Converter{in, codegen.New(), make(map[string]*int), make(codegen.Names), nil, nil}
+	err := c.parseToplevel()
+	return c.gen, err
+}
+
+// AssembleDebug is like Assemble, but also generates debug info giving the
+// position of each instruction in file
+func AssembleDebug(r io.Reader, file string) (codegen.Generator, error) {
+	tok := newTokenizer(file)
+	in := bufio.NewScanner(r)
+	in.Split(tok.split)
+	c := Converter{in, codegen.New(), make(map[string]*int), make(codegen.Names), nil, tok}
+	err := c.parseToplevel()
+	return c.gen, err
+}
@@ -0,0 +1,182 @@
+package asm
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"../bytecode"
+	"../opcode"
+	"../types"
+)
+
+type disassembler struct {
+	w       io.Writer
+	err     error
+	labels  int      // Number of labels named so far
+	structs []string // How to refer to each entry in the struct table in new
+}
+
+// Disassemble converts a GVB file into GVA. The struct table comes first,
+// with structs that have no name, or one that can't be written in GVA,
+// defined as unnamed structs. Structs are referred to by name where that is
+// unambiguous, and otherwise by index. Jump targets are given synthesized
+// labels, local variables are referred to by slot number, and each function
+// that has more or fewer slots than it uses starts with a locals directive.
+//
+// Assembling the result gives back the same file for files written by
+// codegen.Generator, including those from Assemble, with these exceptions:
+//   - Debug info is dropped, as GVA can't give positions. Assembling with
+//     AssembleDebug gives debug info for the GVA instead.
+//   - Struct names that can't be written in GVA are dropped.
+//   - Variable names that can't be written in GVA, such as those containing
+//     spaces, give GVA that can't be assembled.
+//   - Functions with fewer slots than they use give GVA that can't be
+//     assembled, as the locals directive can't reduce the number of slots.
+//
+// Legacy files, and other files whose constants and symbols aren't stored
+// in the order they are first used, are assembled in the current format.
+func Disassemble(w io.Writer, data []byte) error {
+	f, err := bytecode.ParseFile(data)
+	if err != nil {
+		return err
+	}
+	structs, err := bytecode.ReadStructs(f)
+	if err != nil {
+		return err
+	}
+	// Keep the file's own indices
+	index := make([]int, len(structs))
+	for i := range index {
+		index[i] = i
+	}
+	code, _, err := bytecode.DecodeFile(f, index)
+	if err != nil {
+		return err
+	}
+	names, refs := structNames(structs)
+	d := disassembler{w, nil, 0, refs}
+	for i, s := range structs {
+		d.line(0, "struct %s %s", names[i], types.TypeSignature{s.Fields, nil})
+	}
+	d.code(code, 0)
+	return d.err
+}
+
+// structNames returns the name to define each struct with in GVA, and how
+// to refer to it in new
+func structNames(structs []types.StructDef) (names, refs []string) {
+	count := make(map[string]int)
+	for _, s := range structs {
+		count[s.Name]++
+	}
+	names = make([]string, len(structs))
+	refs = make([]string, len(structs))
+	for i, s := range structs {
+		names[i] = s.Name
+		refs[i] = s.Name
+		if _, err := strconv.Atoi(s.Name); err == nil || s.Name == "" || s.Name == "_" ||
+			strings.ContainsAny(s.Name, " \t\n:()[]\"") {
+			names[i] = "_"
+		}
+		if names[i] == "_" || count[s.Name] > 1 {
+			refs[i] = strconv.Itoa(i)
+		}
+	}
+	return
+}
+
+func (d *disassembler) line(depth int, format string, args ...interface{}) {
+	if d.err != nil {
+		return
+	}
+	_, d.err = fmt.Fprintf(d.w, strings.Repeat("\t", depth)+format+"\n", args...)
+}
+
+// code disassembles a block of code, indented by depth tabs
+func (d *disassembler) code(code []types.Instruction, depth int) {
+	labels := make(map[int]string)
+	for _, in := range code {
+		switch in.Op {
+		case opcode.J, opcode.JT, opcode.JF, opcode.JZ, opcode.JNz, opcode.Try:
+			if _, ok := labels[in.Arg]; !ok {
+				labels[in.Arg] = "L" + strconv.Itoa(d.labels)
+				d.labels++
+			}
+		}
+	}
+
+	for i, in := range code {
+		if lbl, ok := labels[i]; ok {
+			d.line(depth, ". %s", lbl)
+		}
+		name := opcode.Name(in.Op)
+		switch in.Op {
+		case opcode.J, opcode.JT, opcode.JF, opcode.JZ, opcode.JNz, opcode.Try:
+			d.line(depth, "%s %s", name, labels[in.Arg])
+		case opcode.Push:
+			val, err := formatValue(in.Val)
+			if err != nil {
+				d.err = err
+				return
+			}
+			d.line(depth, "%s %s", name, val)
+		case opcode.Set, opcode.Get:
+			d.line(depth, "%s @%s", name, in.Val)
+		case opcode.LSet, opcode.LGet:
+			d.line(depth, "%s %d", name, in.Arg)
+		case opcode.Func:
+			f := in.Val.(types.Function)
+			d.line(depth, "%s %s", name, f.Sig)
+			if f.Locals != slotsUsed(f.Code) {
+				d.line(depth+1, "locals %d", f.Locals)
+			}
+			d.code(f.Code, depth+1)
+			d.line(depth, "endfunc")
+		case opcode.New:
+			d.line(depth, "%s %s", name, d.structs[in.Arg])
+		case opcode.FGet, opcode.FSet:
+			d.line(depth, "%s %d", name, in.Arg)
+		case opcode.Make:
+			d.line(depth, "%s %s", name, in.Val)
+		default:
+			d.line(depth, "%s", name)
+		}
+	}
+	if lbl, ok := labels[len(code)]; ok {
+		d.line(depth, ". %s", lbl)
+	}
+}
+
+// slotsUsed returns the number of local variable slots that the assembler
+// would give a function whose body is code
+func slotsUsed(code []types.Instruction) int {
+	n := 0
+	for _, in := range code {
+		if (in.Op == opcode.LSet || in.Op == opcode.LGet) && in.Arg >= n {
+			n = in.Arg + 1
+		}
+	}
+	return n
+}
+
+// formatValue formats a constant so that readValue gives back the same value
+func formatValue(val types.Value) (string, error) {
+	switch val := val.(type) {
+	case int:
+		return strconv.Itoa(val), nil
+	case float64:
+		s := strconv.FormatFloat(val, 'g', -1, 64)
+		if !strings.ContainsAny(s, ".e") && !math.IsInf(val, 0) && !math.IsNaN(val) {
+			s += ".0" // Otherwise it would be read as an int
+		}
+		return s, nil
+	case bool:
+		return strconv.FormatBool(val), nil
+	case string:
+		return strconv.Quote(val), nil
+	default:
+		return "", types.ValueError{val}
+	}
+}
@@ -0,0 +1,45 @@
+package asm
+
+import (
+	"bufio"
+	"io"
+	"../codegen"
+	"../types"
+)
+
+// Session assembles GVA a piece at a time, such as the lines entered in a
+// REPL, for code that is loaded into the same VM. Structs defined by earlier
+// pieces can be used by name in later ones.
+type Session struct {
+	structs codegen.Names
+	defs    []types.StructDef // Structs defined so far, in the order they were defined
+}
+
+func NewSession() *Session {
+	return &Session{make(codegen.Names), nil}
+}
+
+// Assemble converts a piece of GVA. If it ends inside a function or before an
+// instruction's operands, the error is io.ErrUnexpectedEOF. The struct table
+// of the result starts with the structs defined by earlier pieces, which use
+// the same entries in the VM's struct table when it is loaded. The structs
+// defined by a piece are only remembered if the whole piece is converted.
+func (s *Session) Assemble(r io.Reader) (codegen.Generator, error) {
+	in := bufio.NewScanner(r)
+	in.Split(scanToken)
+	structs := make(codegen.Names, len(s.structs))
+	for name, i := range s.structs {
+		structs[name] = i
+	}
+	gen := codegen.New()
+	for _, d := range s.defs {
+		gen.Struct(d.Name, d.Fields)
+	}
+	c := Converter{in, gen, make(map[string]*int), structs, nil, nil}
+	if err := c.parseToplevel(); err != nil {
+		return c.gen, err
+	}
+	s.structs = structs
+	s.defs = c.gen.Structs()
+	return c.gen, nil
+}
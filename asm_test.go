@@ -0,0 +1,222 @@
+package govm
+
+import (
+	"./asm"
+	"./codegen"
+	"./types"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// roundTrip checks that disassembling and reassembling code gives back the
+// same code
+func roundTrip(t *testing.T, name string, code []byte) {
+	gva := bytes.Buffer{}
+	if err := asm.Disassemble(&gva, code); err != nil {
+		t.Errorf("%s: disassemble: %v", name, err)
+		return
+	}
+	g, err := asm.Assemble(strings.NewReader(gva.String()))
+	if err != nil {
+		t.Errorf("%s: assemble: %v\n%s", name, err, gva.String())
+		return
+	}
+	code2, err := g.Generate()
+	if err != nil {
+		t.Errorf("%s: generate: %v", name, err)
+		return
+	}
+	if !bytes.Equal(code, code2) {
+		t.Errorf("%s: round trip differs:\n%s", name, gva.String())
+	}
+}
+
+func TestDisassemble(t *testing.T) {
+	files, err := filepath.Glob("examples/*.gva")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range files {
+		f, err := os.Open(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		g, err := asm.Assemble(f)
+		f.Close()
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		code, err := g.Generate()
+		if err != nil {
+			t.Fatal(err)
+		}
+		roundTrip(t, name, code)
+	}
+
+	g := fizzbuzz()
+	code, err := g.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	roundTrip(t, "fizzbuzz", code)
+
+	// Values that are easy to get wrong, and enough code that tokens cross
+	// the scanner's buffer boundaries
+	src := strings.Builder{}
+	src.WriteString(`
+struct Pair :int:[]string
+push 1.0
+push -2.5e+100
+push 1e21
+push true
+push false
+push "with \"quotes\"\tand\\ escapes\n"
+push "  spaces  "
+push ""
+try catch
+	make map[string][]int
+	make map[string]func(:int->Pair)
+endtry
+. catch
+func :int:Pair->error
+	lset a
+	lset b
+	lget a
+	lget b
+	get @f:func(:int)
+	new Pair
+endfunc
+`)
+	for i := 0; i < 1000; i++ {
+		fmt.Fprintf(&src, "push %d\npush \"string %d\"\nset @x%d\n", i, i, i)
+	}
+	g, err = asm.Assemble(strings.NewReader(src.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, err = g.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	roundTrip(t, "values", code)
+}
+
+// Generator output that Assemble wouldn't produce itself also round trips
+func TestDisassembleGenerator(t *testing.T) {
+	g := codegen.New()
+	anon := g.Struct("", codegen.Types("int"))
+	named := g.Struct("Named", codegen.Types("string:struct(0)"))
+	g.Push(1)
+	g.New(anon)
+	g.Push("x")
+	g.Swp()
+	g.New(named)
+
+	// Slots used out of order, and more slots than are used
+	end := new(int)
+	locals := g.Func(codegen.Sig(":int->int"), end)
+	locals.N = 5
+	g.LSet(3)
+	g.Push(0)
+	g.LSet(1)
+	g.LGet(3)
+	inner := new(int)
+	g.Func(codegen.Sig(":"), inner)
+	g.Label(inner)
+	g.Pop()
+	g.Label(end)
+
+	loop := g.Label(nil)
+	g.Push(true)
+	g.JF(loop)
+	g.Make(types.Type{types.ArrayT, types.TypeSignature{}, 0, &types.Type{types.Struct, types.TypeSignature{}, named, nil, nil}, nil})
+	code, err := g.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	roundTrip(t, "generator", code)
+}
+
+func TestSession(t *testing.T) {
+	v := New()
+	s := asm.NewSession()
+	run := func(src string) error {
+		g, err := s.Assemble(strings.NewReader(src))
+		if err != nil {
+			return err
+		}
+		code, err := g.Generate()
+		if err != nil {
+			return err
+		}
+		return v.Load(code)
+	}
+
+	if err := run("struct A :int\n"); err != nil {
+		t.Fatal(err)
+	}
+	if err := run("func :A->int\n"); err != io.ErrUnexpectedEOF {
+		t.Fatal("Expected unexpected EOF from unfinished function, got", err)
+	}
+	// Structs defined in earlier pieces can be used by name, and share their
+	// entries in the VM's struct table
+	if err := run("struct B :string\npush \"x\"\nnew B\nfunc :A->int\nfget 0\nendfunc\n"); err != nil {
+		t.Fatal(err)
+	}
+	stack := v.Stack()
+	if len(stack) != 2 {
+		t.Fatal("Expected 2 values on the stack, got", stack)
+	}
+	if typ := types.TypeOf(stack[0]); typ.Kind != types.Struct || typ.I != 1 {
+		t.Error("Expected struct 1, got", typ)
+	}
+	if sig := stack[1].(types.Function).Sig; sig.Args[0].I != 0 {
+		t.Error("Expected function of struct 0, got", sig)
+	}
+	if n := len(v.Structs()); n != 2 {
+		t.Error("Expected 2 structs in the VM, got", n)
+	}
+}
+
+func TestLocalsDirective(t *testing.T) {
+	for _, test := range []struct {
+		src string
+		n   int // Slots of the function, or -1 for a LocalsError
+	}{
+		{"func :\nlocals 3\nlset a\nendfunc\n", 3},
+		{"func :\nlset 1\nlocals 2\nendfunc\n", 2},
+		{"func :\nlocals -1\nendfunc\n", -1},
+		{"func :\nlocals 1000000\nendfunc\n", -1},
+		{"func :\nlocals 1\nlset a\nlset b\nendfunc\n", -1},
+		{"func :\nlset 2\nlocals 2\nendfunc\n", -1},
+	} {
+		g, err := asm.Assemble(strings.NewReader(test.src))
+		if test.n < 0 {
+			if !errors.As(err, new(asm.LocalsError)) {
+				t.Errorf("%q: expected locals error, got %v", test.src, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", test.src, err)
+			continue
+		}
+		code, err := g.Generate()
+		if err != nil {
+			t.Fatal(err)
+		}
+		v := New()
+		if err := v.Load(code); err != nil {
+			t.Fatal(err)
+		}
+		if f, _ := v.Pop(); f.(types.Function).Locals != test.n {
+			t.Errorf("%q: expected %d locals, got %d", test.src, test.n, f.(types.Function).Locals)
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package govm
+
+import (
+	"./codegen"
+	"./types"
+	"testing"
+)
+
+// benchFizzbuzz runs fizzbuzz b.N times, with Println stubbed out
+func benchFizzbuzz(b *testing.B, v VM) {
+	g := fizzbuzz()
+	code, err := g.Generate()
+	if err != nil {
+		b.Fatal(err)
+	}
+	v.Builtin(codegen.Sig(":string"), func(_ types.Caller, a ...types.Value) ([]types.Value, error) {
+		return nil, nil
+	})
+	v.Set("Println:string")
+	if err := v.Load(code); err != nil {
+		b.Fatal("Load:", err)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		v.Get("Main:")
+		if err := v.Call(); err != nil {
+			b.Fatal("Call:", err)
+		}
+	}
+}
+
+func BenchmarkFizzbuzz(b *testing.B) {
+	benchFizzbuzz(b, New())
+}
+
+func BenchmarkFizzbuzzTrace(b *testing.B) {
+	v := New()
+	v.Trace = func(TraceEvent) {}
+	benchFizzbuzz(b, v)
+}
+
+func BenchmarkFizzbuzzProfile(b *testing.B) {
+	v := New()
+	v.Profile = NewProfile()
+	benchFizzbuzz(b, v)
+}
@@ -0,0 +1,64 @@
+package govm
+
+import (
+	"./codegen"
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func loop(t *testing.T) []byte {
+	g := codegen.New()
+	end := new(int)
+	g.Func(codegen.Sig(":"), end)
+	start := g.Label(nil)
+	g.Push(1)
+	g.Pop()
+	g.J(start)
+	g.Label(end)
+	g.Set("loop:")
+	code, err := g.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return code
+}
+
+func TestBudget(t *testing.T) {
+	v := New()
+	if err := v.Load(loop(t)); err != nil {
+		t.Fatal("Load:", err)
+	}
+	v.Budget = 1000
+	v.Push(42)
+	v.Get("loop:")
+	if err := v.Call(); !errors.Is(err, ErrBudgetExhausted) {
+		t.Fatal("Expected budget to be exhausted, got", err)
+	}
+	if len(v.stack) != 1 || v.stack[0] != 42 {
+		t.Error("Expected stack to be restored, got", v.stack)
+	}
+
+	// The budget is reset for each call
+	v.Get("loop:")
+	if err := v.Call(); !errors.Is(err, ErrBudgetExhausted) {
+		t.Fatal("Expected budget to be exhausted, got", err)
+	}
+}
+
+func TestCallContext(t *testing.T) {
+	v := New()
+	if err := v.Load(loop(t)); err != nil {
+		t.Fatal("Load:", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	v.Get("loop:")
+	if err := v.CallContext(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatal("Expected deadline to be exceeded, got", err)
+	}
+	if len(v.stack) != 0 {
+		t.Error("Expected empty stack, got", v.stack)
+	}
+}
@@ -0,0 +1,100 @@
+package bytecode
+
+import (
+	"bytes"
+	"../types"
+)
+
+// DebugInfo writes debug info. File names are stored once, in a table
+// before the entries that refer to them.
+func (w *Writer) DebugInfo(d *types.DebugInfo) error {
+	var files []string
+	index := make(map[string]int)
+	for _, p := range d.Pos {
+		if _, ok := index[p.File]; !ok {
+			index[p.File] = len(files)
+			files = append(files, p.File)
+		}
+	}
+
+	if err := w.Int(len(files)); err != nil {
+		return err
+	}
+	for _, f := range files {
+		if err := w.String(f); err != nil {
+			return err
+		}
+	}
+	if err := w.Int(len(d.Offsets)); err != nil {
+		return err
+	}
+	for i, off := range d.Offsets {
+		p := d.Pos[i]
+		for _, n := range []int{off, index[p.File], p.Line, p.Col} {
+			if err := w.Int(n); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (r *Reader) DebugInfo() (*types.DebugInfo, error) {
+	n, err := r.length(4 /* int string length */)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for i := 0; i < n; i++ {
+		f, err := r.String()
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, f)
+	}
+
+	if n, err = r.length(4 * 4 /* int offset, file, line and column */); err != nil {
+		return nil, err
+	}
+	d := &types.DebugInfo{}
+	for i := 0; i < n; i++ {
+		var e [4]int // Offset, file, line, column
+		for j := range e {
+			if e[j], err = r.Int(); err != nil {
+				return nil, err
+			}
+		}
+		if e[1] < 0 || e[1] >= len(files) {
+			return nil, SectionError{DebugSection, "invalid file index"}
+		}
+		if len(d.Offsets) > 0 && e[0] < d.Offsets[len(d.Offsets)-1] {
+			return nil, SectionError{DebugSection, "offsets out of order"}
+		}
+		d.Offsets = append(d.Offsets, e[0])
+		d.Pos = append(d.Pos, types.Pos{files[e[1]], e[2], e[3]})
+	}
+	return d, nil
+}
+
+// ReadDebugInfo reads the debug section of f. It returns nil if f has none.
+func ReadDebugInfo(f File) (*types.DebugInfo, error) {
+	data, ok := f.Sections[DebugSection]
+	if !ok {
+		return nil, nil
+	}
+	d, err := NewSliceReader(data).DebugInfo()
+	if _, ok := err.(SectionError); err != nil && !ok {
+		err = SectionError{DebugSection, err.Error()}
+	}
+	return d, err
+}
+
+// AddDebugInfo adds a debug section to f
+func AddDebugInfo(f File, d *types.DebugInfo) error {
+	buf := bytes.Buffer{}
+	if err := NewWriter(&buf).DebugInfo(d); err != nil {
+		return err
+	}
+	f.Sections[DebugSection] = buf.Bytes()
+	return nil
+}
@@ -0,0 +1,194 @@
+package bytecode
+
+import (
+	"fmt"
+	"io"
+	"../opcode"
+	"../types"
+)
+
+type InvalidOpcodeError struct {
+	Offset int
+	Byte   byte
+}
+
+func (e InvalidOpcodeError) Error() string {
+	return fmt.Sprintf("Invalid opcode %#02x at offset %d", e.Byte, e.Offset)
+}
+
+type JumpError struct{ Offset, Target int }
+
+func (e JumpError) Error() string {
+	return fmt.Sprintf("Invalid jump from offset %d to offset %d", e.Offset, e.Target)
+}
+
+// Decode decodes code into a slice of instructions, so that it doesn't need
+// to be parsed again each time it is executed. The bodies of functions are
+// decoded into the types.Function stored in the Val of their func
+// instruction, and jump targets are resolved to instruction indices. Code
+// without a struct table can't use structs.
+func Decode(code []byte) ([]types.Instruction, error) {
+	return decode(code, 0, nil, nil, nil)
+}
+
+// DecodeFile decodes the code section of f. If f has a constant pool, the
+// operands of push, get and set are resolved from it. If f has debug info, it
+// is returned and stored in each function it creates. Each struct index i in
+// the code, which refers to f's struct section, is replaced by structs[i],
+// as returned by LinkStructs.
+func DecodeFile(f File, structs []int) ([]types.Instruction, *types.DebugInfo, error) {
+	pool, err := ReadPool(f)
+	if err != nil {
+		return nil, nil, err
+	}
+	debug, err := ReadDebugInfo(f)
+	if err != nil {
+		return nil, nil, err
+	}
+	code, err := decode(f.Sections[CodeSection], 0, pool, debug, structs)
+	return code, debug, err
+}
+
+// decode decodes code which starts at offset base in the outermost code. If
+// pool is nil, constants and symbols are stored inline.
+func decode(code []byte, base int, pool *Pool, debug *types.DebugInfo, structs []int) ([]types.Instruction, error) {
+	var instrs []types.Instruction
+	r := NewSliceReader(code)
+	for {
+		off := r.Offset()
+		op, err := r.ReadByte()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		in := types.Instruction{op, base + off, 0, nil}
+		switch op {
+		case opcode.J, opcode.JT, opcode.JF, opcode.JZ, opcode.JNz, opcode.Try:
+			// Resolved to an index once all the instructions are decoded
+			rel, err := r.Int()
+			if err != nil {
+				return nil, err
+			}
+			in.Arg = r.Offset() + rel
+
+		case opcode.Push:
+			if pool == nil {
+				if in.Val, err = r.TypedValue(); err != nil {
+					return nil, err
+				}
+				break
+			}
+			i, err := r.Int()
+			if err != nil {
+				return nil, err
+			}
+			if i < 0 || i >= len(pool.Constants) {
+				return nil, PoolError{base + off, ConstantSection, i}
+			}
+			in.Val = pool.Constants[i]
+
+		case opcode.Set, opcode.Get:
+			if pool == nil {
+				s, err := r.String()
+				if err != nil {
+					return nil, err
+				}
+				in.Val = types.Symbol(s)
+				break
+			}
+			i, err := r.Int()
+			if err != nil {
+				return nil, err
+			}
+			if i < 0 || i >= len(pool.Symbols) {
+				return nil, PoolError{base + off, SymbolSection, i}
+			}
+			in.Val = pool.Symbols[i]
+
+		case opcode.Func:
+			sig, err := r.TypeSignature()
+			if err != nil {
+				return nil, err
+			}
+			if sig, err = rebaseSig(sig, structs, base+off); err != nil {
+				return nil, err
+			}
+			locals, err := r.Int()
+			if err != nil {
+				return nil, err
+			}
+			if locals < 0 || locals > MaxLocals {
+				return nil, LengthError{locals}
+			}
+			body, err := r.Bytes()
+			if err != nil {
+				return nil, err
+			}
+			bodyCode, err := decode(body, base+r.Offset()-len(body), pool, debug, structs)
+			if err != nil {
+				return nil, err
+			}
+			in.Val = types.Function{sig, locals, bodyCode, nil, debug}
+
+		case opcode.Make:
+			t, err := r.Type()
+			if err != nil {
+				return nil, err
+			}
+			if in.Val, err = rebase(t, structs, base+off); err != nil {
+				return nil, err
+			}
+
+		case opcode.New:
+			i, err := r.Int()
+			if err != nil {
+				return nil, err
+			}
+			if i < 0 || i >= len(structs) {
+				return nil, PoolError{base + off, StructSection, i}
+			}
+			in.Arg = structs[i]
+
+		case opcode.LSet, opcode.LGet, opcode.FGet, opcode.FSet:
+			if in.Arg, err = r.Int(); err != nil {
+				return nil, err
+			}
+
+		case opcode.Pop, opcode.Dup, opcode.Swp,
+			opcode.Inc, opcode.Dec, opcode.Add, opcode.Sub, opcode.Mul, opcode.Div, opcode.Mod,
+			opcode.EQ, opcode.NE, opcode.LT, opcode.GT, opcode.LE, opcode.GE,
+			opcode.And, opcode.Or, opcode.Xor, opcode.Not,
+			opcode.BAnd, opcode.BOr, opcode.BXor, opcode.BNot, opcode.BLS, opcode.BRS,
+			opcode.BSet, opcode.BClr, opcode.BTgl, opcode.BMtch,
+			opcode.Call, opcode.Ret,
+			opcode.Index, opcode.Store, opcode.Len, opcode.Append, opcode.Slice,
+			opcode.Lookup, opcode.Delete, opcode.Keys, opcode.SKeys,
+			opcode.Throw, opcode.EndTry:
+			// No operands
+
+		default:
+			return nil, InvalidOpcodeError{base + off, op}
+		}
+		instrs = append(instrs, in)
+	}
+
+	// Resolve jump targets. Jumping to the end of the code is allowed
+	index := make(map[int]int, len(instrs)+1)
+	for i, in := range instrs {
+		index[in.Offset-base] = i
+	}
+	index[len(code)] = len(instrs)
+	for i := range instrs {
+		switch instrs[i].Op {
+		case opcode.J, opcode.JT, opcode.JF, opcode.JZ, opcode.JNz, opcode.Try:
+			target, ok := index[instrs[i].Arg]
+			if !ok {
+				return nil, JumpError{instrs[i].Offset, base + instrs[i].Arg}
+			}
+			instrs[i].Arg = target
+		}
+	}
+	return instrs, nil
+}
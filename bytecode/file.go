@@ -0,0 +1,198 @@
+package bytecode
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// Magic is the first 4 bytes of a GVB file with a header. The first byte is
+// not a valid opcode, so files without a header can still be recognised.
+const Magic = "\x7fGVB"
+
+// The version of the format written by this package. Files with a different
+// major version can't be read. Newer minor versions only add features that
+// older readers can safely ignore, such as new sections.
+const (
+	Major = 1
+	Minor = 0
+)
+
+type Section byte
+
+const (
+	CodeSection Section = 1 + iota
+	ConstantSection
+	SymbolSection
+	DebugSection
+	StructSection
+)
+
+func (s Section) String() string {
+	switch s {
+	case CodeSection:
+		return "code"
+	case ConstantSection:
+		return "constants"
+	case SymbolSection:
+		return "symbols"
+	case DebugSection:
+		return "debug"
+	case StructSection:
+		return "structs"
+	default:
+		return fmt.Sprintf("section(%d)", byte(s))
+	}
+}
+
+// File is a GVB file split into its sections
+type File struct {
+	Major, Minor byte
+	Flags        uint16
+	Legacy       bool // The file has no header, so is entirely code
+	Sections     map[Section][]byte
+}
+
+// NewFile creates an empty file with the current version
+func NewFile() File {
+	return File{Major, Minor, 0, false, make(map[Section][]byte)}
+}
+
+type VersionError struct{ Major, Minor byte }
+
+func (e VersionError) Error() string {
+	return fmt.Sprintf("Unsupported GVB version %d.%d (supported: %d.x)", e.Major, e.Minor, Major)
+}
+
+type FlagsError struct{ Flags uint16 }
+
+func (e FlagsError) Error() string {
+	return fmt.Sprintf("Unsupported GVB flags %#04x", e.Flags)
+}
+
+type SectionError struct {
+	Section Section
+	Reason  string
+}
+
+func (e SectionError) Error() string {
+	return fmt.Sprintf("Invalid %s section: %s", e.Section, e.Reason)
+}
+
+// ParseFile splits a GVB file into its sections. A file that doesn't start
+// with Magic is treated as a legacy file, which consists only of code.
+func ParseFile(data []byte) (File, error) {
+	if !bytes.HasPrefix(data, []byte(Magic)) {
+		return File{0, 0, 0, true, map[Section][]byte{CodeSection: data}}, nil
+	}
+
+	f := File{Sections: make(map[Section][]byte)}
+	r := NewSliceReader(data[len(Magic):])
+	var err error
+	if f.Major, err = r.ReadByte(); err != nil {
+		return f, io.ErrUnexpectedEOF
+	}
+	if f.Minor, err = r.ReadByte(); err != nil {
+		return f, io.ErrUnexpectedEOF
+	}
+	if f.Major != Major {
+		return f, VersionError{f.Major, f.Minor}
+	}
+	if err := binary.Read(r, binary.BigEndian, &f.Flags); err != nil {
+		return f, io.ErrUnexpectedEOF
+	}
+	// No flags are defined yet
+	if f.Flags != 0 {
+		return f, FlagsError{f.Flags}
+	}
+
+	n, err := r.Int()
+	if err != nil {
+		return f, io.ErrUnexpectedEOF
+	}
+	// Too many sections is reported as a truncated header
+	if n < 0 {
+		return f, LengthError{n}
+	}
+	for i := 0; i < n; i++ {
+		kind, err := r.ReadByte()
+		if err != nil {
+			return f, io.ErrUnexpectedEOF
+		}
+		off, err := r.Int()
+		if err != nil {
+			return f, io.ErrUnexpectedEOF
+		}
+		l, err := r.Int()
+		if err != nil {
+			return f, io.ErrUnexpectedEOF
+		}
+		s := Section(kind)
+		if off < 0 || l < 0 || off > len(data) || l > len(data)-off {
+			return f, SectionError{s, "out of bounds"}
+		}
+		if _, ok := f.Sections[s]; ok {
+			return f, SectionError{s, "duplicate"}
+		}
+		f.Sections[s] = data[off : off+l]
+	}
+	if _, ok := f.Sections[CodeSection]; !ok {
+		return f, SectionError{CodeSection, "missing"}
+	}
+	return f, nil
+}
+
+// HeaderSize returns the size of the header of a file with n sections
+func HeaderSize(n int) int {
+	return len(Magic) + 2 /* version */ + 2 /* flags */ + 4 /* int number of sections */ +
+		n*(1 /* kind */ + 4 /* int offset */ + 4 /* int length */)
+}
+
+// File writes a GVB file. Sections are written in order of their kind, and
+// legacy files are written without a header.
+func (w *Writer) File(f File) error {
+	if f.Legacy {
+		_, err := w.Write(f.Sections[CodeSection])
+		return err
+	}
+
+	var kinds []Section
+	for s := range f.Sections {
+		kinds = append(kinds, s)
+	}
+	sort.Slice(kinds, func(i, j int) bool { return kinds[i] < kinds[j] })
+
+	if _, err := w.Write([]byte(Magic)); err != nil {
+		return err
+	}
+	if _, err := w.Write([]byte{f.Major, f.Minor}); err != nil {
+		return err
+	}
+	if err := binary.Write(w, binary.BigEndian, f.Flags); err != nil {
+		return err
+	}
+	if err := w.Int(len(kinds)); err != nil {
+		return err
+	}
+	off := HeaderSize(len(kinds))
+	for _, s := range kinds {
+		if err := w.WriteByte(byte(s)); err != nil {
+			return err
+		}
+		if err := w.Int(off); err != nil {
+			return err
+		}
+		if err := w.Int(len(f.Sections[s])); err != nil {
+			return err
+		}
+		off += len(f.Sections[s])
+	}
+	for _, s := range kinds {
+		if _, err := w.Write(f.Sections[s]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,130 @@
+package bytecode
+
+import (
+	"bytes"
+	"fmt"
+	"../types"
+)
+
+// Pool holds the constants and symbols of a file, which instructions refer to
+// by index rather than storing inline
+type Pool struct {
+	Constants []types.Value
+	Symbols   []types.Symbol
+
+	constants map[types.Value]int
+	symbols   map[types.Symbol]int
+}
+
+type PoolError struct {
+	Offset  int
+	Section Section
+	Index   int
+}
+
+func (e PoolError) Error() string {
+	return fmt.Sprintf("Invalid %s index %d at offset %d", e.Section, e.Index, e.Offset)
+}
+
+// Constant returns the index of val in the constant pool, adding it if needed
+func (p *Pool) Constant(val types.Value) int {
+	switch val.(type) {
+	case int, float64, bool, string:
+	default:
+		// Can't be written, but may not be comparable either. Writing the
+		// pool will fail.
+		p.Constants = append(p.Constants, val)
+		return len(p.Constants) - 1
+	}
+	if i, ok := p.constants[val]; ok {
+		return i
+	}
+	if p.constants == nil {
+		p.constants = make(map[types.Value]int)
+	}
+	p.constants[val] = len(p.Constants)
+	p.Constants = append(p.Constants, val)
+	return len(p.Constants) - 1
+}
+
+// Symbol returns the index of s in the symbol table, adding it if needed
+func (p *Pool) Symbol(s types.Symbol) int {
+	if i, ok := p.symbols[s]; ok {
+		return i
+	}
+	if p.symbols == nil {
+		p.symbols = make(map[types.Symbol]int)
+	}
+	p.symbols[s] = len(p.Symbols)
+	p.Symbols = append(p.Symbols, s)
+	return len(p.Symbols) - 1
+}
+
+// AddTo writes the pool's sections to f
+func (p *Pool) AddTo(f File) error {
+	buf := bytes.Buffer{}
+	w := NewWriter(&buf)
+	if err := w.Int(len(p.Constants)); err != nil {
+		return err
+	}
+	for _, c := range p.Constants {
+		if err := w.TypedValue(c); err != nil {
+			return err
+		}
+	}
+	f.Sections[ConstantSection] = buf.Bytes()
+
+	buf = bytes.Buffer{}
+	w = NewWriter(&buf)
+	if err := w.Int(len(p.Symbols)); err != nil {
+		return err
+	}
+	for _, s := range p.Symbols {
+		if err := w.String(string(s)); err != nil {
+			return err
+		}
+	}
+	f.Sections[SymbolSection] = buf.Bytes()
+	return nil
+}
+
+// ReadPool reads the constant and symbol sections of f. It returns nil if f
+// has neither, in which case operands are stored inline. Each symbol is only
+// allocated once, however many instructions refer to it.
+func ReadPool(f File) (*Pool, error) {
+	consts, hasConsts := f.Sections[ConstantSection]
+	syms, hasSyms := f.Sections[SymbolSection]
+	if !hasConsts && !hasSyms {
+		return nil, nil
+	}
+	if !hasConsts || !hasSyms {
+		return nil, SectionError{ConstantSection, "constants and symbols must be used together"}
+	}
+
+	p := &Pool{}
+	r := NewSliceReader(consts)
+	n, err := r.length(1 /* kind */)
+	if err != nil {
+		return nil, SectionError{ConstantSection, err.Error()}
+	}
+	for i := 0; i < n; i++ {
+		c, err := r.TypedValue()
+		if err != nil {
+			return nil, SectionError{ConstantSection, err.Error()}
+		}
+		p.Constants = append(p.Constants, c)
+	}
+
+	r = NewSliceReader(syms)
+	if n, err = r.length(4 /* int string length */); err != nil {
+		return nil, SectionError{SymbolSection, err.Error()}
+	}
+	for i := 0; i < n; i++ {
+		s, err := r.String()
+		if err != nil {
+			return nil, SectionError{SymbolSection, err.Error()}
+		}
+		p.Symbols = append(p.Symbols, types.Symbol(s))
+	}
+	return p, nil
+}
@@ -3,12 +3,31 @@ package bytecode
 import (
 	"bytes"
 	"encoding/binary"
+	"fmt"
 	"io"
 	"../types"
 )
 
 type Reader struct { R io.ReadSeeker }
 
+type KindError struct{ Kind types.Kind }
+
+func (e KindError) Error() string {
+	return fmt.Sprintf("Unknown kind: %#02x", byte(e.Kind))
+}
+
+// LengthError is returned when a length or count read from the code is
+// negative or larger than the rest of the code could hold, or than MaxLocals
+// for the number of local slots of a function
+type LengthError struct{ Length int }
+
+// MaxLocals is the largest number of local slots a function may have
+const MaxLocals = 1 << 16
+
+func (e LengthError) Error() string {
+	return fmt.Sprintf("Invalid length: %d", e.Length)
+}
+
 func NewSliceReader(code []byte) *Reader {
 	return &Reader{bytes.NewReader(code)}
 }
@@ -27,12 +46,36 @@ func (r *Reader) Seek(off int64, whence int) (int64, error) {
 	return r.R.Seek(off, whence)
 }
 
+// Offset returns the current position of the reader
+func (r *Reader) Offset() int {
+	off, _ := r.R.Seek(0, io.SeekCurrent)
+	return int(off)
+}
+
 func (r *Reader) Int() (int, error) {
 	var i int32
 	err := binary.Read(r, binary.BigEndian, &i)
 	return int(i), err
 }
 
+// length reads the length of something made up of items that are each at
+// least size bytes long, checking that there are enough bytes left for them
+func (r *Reader) length(size int) (int, error) {
+	n, err := r.Int()
+	if err != nil {
+		return 0, err
+	}
+	cur, _ := r.R.Seek(0, io.SeekCurrent)
+	end, _ := r.R.Seek(0, io.SeekEnd)
+	if _, err := r.R.Seek(cur, io.SeekStart); err != nil {
+		return 0, err
+	}
+	if n < 0 || n > int(end-cur)/size {
+		return 0, LengthError{n}
+	}
+	return n, nil
+}
+
 func (r *Reader) Float() (f float64, err error) {
 	err = binary.Read(r, binary.BigEndian, &f)
 	return
@@ -44,7 +87,7 @@ func (r *Reader) Bool() (b bool, err error) {
 }
 
 func (r *Reader) Bytes() ([]byte, error) {
-	l, err := r.Int()
+	l, err := r.length(1)
 	if err != nil {
 		return nil, err
 	}
@@ -74,14 +117,38 @@ func (r *Reader) Type() (types.Type, error) {
 
 	k := types.Kind(b)
 	switch k {
-	case types.Int, types.Float, types.Bool, types.String:
-		return types.Type{k, types.TypeSignature{}, 0}, nil
+	case types.Int, types.Float, types.Bool, types.String, types.ErrorT:
+		return types.Type{k, types.TypeSignature{}, 0, nil, nil}, nil
 	case types.Struct:
-		panic("Cannot read struct type")
+		i, err := r.Int()
+		if err != nil {
+			return types.Type{}, err
+		}
+		return types.Type{k, types.TypeSignature{}, i, nil, nil}, nil
+	case types.ArrayT:
+		elem, err := r.Type()
+		if err != nil {
+			return types.Type{}, err
+		}
+		return types.Type{k, types.TypeSignature{}, 0, &elem, nil}, nil
+	case types.MapT:
+		key, err := r.Type()
+		if err != nil {
+			return types.Type{}, err
+		}
+		elem, err := r.Type()
+		if err != nil {
+			return types.Type{}, err
+		}
+		return types.Type{k, types.TypeSignature{}, 0, &elem, &key}, nil
 	case types.FuncT:
-		panic("Cannot read function type")
+		sig, err := r.TypeSignature()
+		if err != nil {
+			return types.Type{}, err
+		}
+		return types.Type{k, sig, 0, nil, nil}, nil
 	default:
-		panic("Unknown kind")
+		return types.Type{}, KindError{k}
 	}
 }
 
@@ -100,40 +167,36 @@ func (r *Reader) TypedValue() (types.Value, error) {
 		return r.Bool()
 	case types.String:
 		return r.String()
-	case types.Struct:
-		panic("structs not implemented")
 	default:
-		panic("Unknown type")
+		// Other kinds of values are constructed at runtime
+		return nil, KindError{t.Kind}
 	}
 }
 
-func (r *Reader) TypeSignature() (types.TypeSignature, error) {
-	var ts types.TypeSignature
-	nargs, err := r.Int()
+func (r *Reader) Types() ([]types.Type, error) {
+	n, err := r.length(1 /* kind */)
 	if err != nil {
-		return types.TypeSignature{}, err
+		return nil, err
 	}
-	ts.Args = make([]types.Type, nargs)
-	for i := 0; i < nargs; i++ {
+	ts := make([]types.Type, n)
+	for i := 0; i < n; i++ {
 		if t, err := r.Type(); err != nil {
-			return types.TypeSignature{}, err
+			return nil, err
 		} else {
-			ts.Args[i] = t
+			ts[i] = t
 		}
 	}
+	return ts, nil
+}
 
-	nret, err := r.Int()
-	if err != nil {
+func (r *Reader) TypeSignature() (types.TypeSignature, error) {
+	var ts types.TypeSignature
+	var err error
+	if ts.Args, err = r.Types(); err != nil {
 		return types.TypeSignature{}, err
 	}
-	ts.Ret = make([]types.Type, nret)
-	for i := 0; i < nret; i++ {
-		if t, err := r.Type(); err != nil {
-			return types.TypeSignature{}, err
-		} else {
-			ts.Ret[i] = t
-		}
+	if ts.Ret, err = r.Types(); err != nil {
+		return types.TypeSignature{}, err
 	}
-
 	return ts, nil
 }
@@ -0,0 +1,177 @@
+package bytecode
+
+import (
+	"bytes"
+	"../types"
+)
+
+// Structs writes a struct table. Each entry is its name followed by its
+// field types.
+func (w *Writer) Structs(defs []types.StructDef) error {
+	if err := w.Int(len(defs)); err != nil {
+		return err
+	}
+	for _, d := range defs {
+		if err := w.String(d.Name); err != nil {
+			return err
+		}
+		if err := w.Types(d.Fields); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Structs reads a struct table. The fields of each struct may only refer to
+// the structs before it.
+func (r *Reader) Structs() ([]types.StructDef, error) {
+	n, err := r.length(4 /* int name length */ + 4 /* int number of fields */)
+	if err != nil {
+		return nil, err
+	}
+	var defs []types.StructDef
+	var index []int // Checks indices without changing them
+	for i := 0; i < n; i++ {
+		name, err := r.String()
+		if err != nil {
+			return nil, err
+		}
+		fields, err := r.Types()
+		if err != nil {
+			return nil, err
+		}
+		for _, t := range fields {
+			if _, err := rebase(t, index, 0); err != nil {
+				return nil, SectionError{StructSection, "fields may only refer to earlier structs"}
+			}
+		}
+		defs = append(defs, types.StructDef{name, fields})
+		index = append(index, i)
+	}
+	return defs, nil
+}
+
+// ReadStructs reads the struct section of f. It returns nil if f has none.
+func ReadStructs(f File) ([]types.StructDef, error) {
+	data, ok := f.Sections[StructSection]
+	if !ok {
+		return nil, nil
+	}
+	defs, err := NewSliceReader(data).Structs()
+	if _, ok := err.(SectionError); err != nil && !ok {
+		err = SectionError{StructSection, err.Error()}
+	}
+	return defs, err
+}
+
+// AddStructs adds a struct section to f
+func AddStructs(f File, defs []types.StructDef) error {
+	buf := bytes.Buffer{}
+	if err := NewWriter(&buf).Structs(defs); err != nil {
+		return err
+	}
+	f.Sections[StructSection] = buf.Bytes()
+	return nil
+}
+
+// LinkStructs adds the structs of a file to table, the struct table of the
+// VM it is being loaded into. It returns the new table and the index in it
+// of each of the file's structs, to be passed to DecodeFile. A named struct
+// that has the same name and fields as one already in the table uses that
+// entry rather than adding another, so that code loaded from separate files,
+// such as the lines entered in a REPL, can share it.
+//
+// table is not modified, though the new table may share its storage.
+func LinkStructs(table, defs []types.StructDef) ([]types.StructDef, []int) {
+	// Make the first append copy the table
+	table = table[:len(table):len(table)]
+	index := make([]int, len(defs))
+	for i, d := range defs {
+		fields := make([]types.Type, len(d.Fields))
+		for j, t := range d.Fields {
+			// Checked by Reader.Structs
+			fields[j], _ = rebase(t, index[:i], 0)
+		}
+		index[i] = len(table)
+		if d.Name != "" {
+			for j := len(table) - 1; j >= 0; j-- {
+				if table[j].Name == d.Name && sameTypes(table[j].Fields, fields) {
+					index[i] = j
+					break
+				}
+			}
+		}
+		if index[i] == len(table) {
+			table = append(table, types.StructDef{d.Name, fields})
+		}
+	}
+	return table, index
+}
+
+// rebase returns t with each struct index i replaced by structs[i]. The
+// error is a PoolError at offset off if i is out of range.
+func rebase(t types.Type, structs []int, off int) (types.Type, error) {
+	switch t.Kind {
+	case types.Struct:
+		if t.I < 0 || t.I >= len(structs) {
+			return t, PoolError{off, StructSection, t.I}
+		}
+		t.I = structs[t.I]
+	case types.ArrayT, types.MapT:
+		elem, err := rebase(*t.Elem, structs, off)
+		if err != nil {
+			return t, err
+		}
+		t.Elem = &elem
+		if t.Key != nil {
+			key, err := rebase(*t.Key, structs, off)
+			if err != nil {
+				return t, err
+			}
+			t.Key = &key
+		}
+	case types.FuncT:
+		sig, err := rebaseSig(t.Sig, structs, off)
+		if err != nil {
+			return t, err
+		}
+		t.Sig = sig
+	}
+	return t, nil
+}
+
+func rebaseSig(sig types.TypeSignature, structs []int, off int) (types.TypeSignature, error) {
+	var err error
+	if sig.Args, err = rebaseTypes(sig.Args, structs, off); err != nil {
+		return sig, err
+	}
+	sig.Ret, err = rebaseTypes(sig.Ret, structs, off)
+	return sig, err
+}
+
+func rebaseTypes(ts []types.Type, structs []int, off int) ([]types.Type, error) {
+	if ts == nil {
+		return nil, nil
+	}
+	out := make([]types.Type, len(ts))
+	for i, t := range ts {
+		var err error
+		if out[i], err = rebase(t, structs, off); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+// sameTypes returns whether two lists of types are identical
+func sameTypes(a, b []types.Type) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !a[i].Equal(b[i]) {
+			return false
+		}
+	}
+	return true
+}
@@ -8,12 +8,16 @@ import (
 
 func SizeOfType(t types.Type) int {
 	switch t.Kind {
-	case types.Int, types.Float, types.Bool, types.String:
+	case types.Int, types.Float, types.Bool, types.String, types.ErrorT:
 		return 1 // Single-byte representation
 	case types.FuncT:
-		return 1 /* kind */ + SizeOf(t.Sig) + 4 /* int for length of body */
+		return 1 /* kind */ + SizeOf(t.Sig)
 	case types.Struct:
-		panic("Structs are not implemented")
+		return 1 /* kind */ + 4 /* int index in struct table */
+	case types.ArrayT:
+		return 1 /* kind */ + SizeOfType(*t.Elem)
+	case types.MapT:
+		return 1 /* kind */ + SizeOfType(*t.Key) + SizeOfType(*t.Elem)
 	default:
 		panic("Unknown kind")
 	}
@@ -32,15 +36,14 @@ func SizeOf(val types.Value) int {
 		return SizeOf(n) + n
 	case types.Type:
 		return SizeOfType(val)
-	case types.TypeSignature:
-		s := 8 // 2 ints
-		for _, t := range val.Args {
-			s += SizeOfType(t)
-		}
-		for _, t := range val.Ret {
+	case []types.Type:
+		s := 4 // int for length
+		for _, t := range val {
 			s += SizeOfType(t)
 		}
 		return s
+	case types.TypeSignature:
+		return SizeOf(val.Args) + SizeOf(val.Ret)
 	default:
 		panic("Unknown type")
 	}
@@ -94,9 +97,28 @@ func (w *Writer) Type(t types.Type) error {
 	switch t.Kind {
 	case types.Int, types.Float, types.Bool, types.String:
 	case types.Struct:
-		panic("Cannot write struct type")
+		if err := w.WriteByte(byte(t.Kind)); err != nil {
+			return err
+		}
+		return w.Int(t.I)
+	case types.ArrayT:
+		if err := w.WriteByte(byte(t.Kind)); err != nil {
+			return err
+		}
+		return w.Type(*t.Elem)
+	case types.MapT:
+		if err := w.WriteByte(byte(t.Kind)); err != nil {
+			return err
+		}
+		if err := w.Type(*t.Key); err != nil {
+			return err
+		}
+		return w.Type(*t.Elem)
 	case types.FuncT:
-		panic("Cannot write function type")
+		if err := w.WriteByte(byte(t.Kind)); err != nil {
+			return err
+		}
+		return w.TypeSignature(t.Sig)
 	}
 	return w.WriteByte(byte(t.Kind))
 }
@@ -115,11 +137,15 @@ func (w *Writer) Value(val types.Value) error {
 	// These two are mainly use from the codegen package
 	case *int: // This is a label
 		return w.Int(*val - w.off - 4 /* -4 because we jump from the end of the int not the beginning of it */)
+	case []types.Type:
+		return w.Types(val)
 	case types.TypeSignature:
 		return w.TypeSignature(val)
+	case types.Type:
+		return w.Type(val)
 
 	default:
-		panic("Unknown type")
+		return types.ValueError{val}
 	}
 }
 
@@ -130,22 +156,21 @@ func (w *Writer) TypedValue(val types.Value) error {
 	return w.Value(val)
 }
 
-func (w *Writer) TypeSignature(ts types.TypeSignature) error {
-	if err := w.Int(len(ts.Args)); err != nil {
+func (w *Writer) Types(ts []types.Type) error {
+	if err := w.Int(len(ts)); err != nil {
 		return err
 	}
-	for _, t := range ts.Args {
+	for _, t := range ts {
 		if err := w.Type(t); err != nil {
 			return err
 		}
 	}
-	if err := w.Int(len(ts.Ret)); err != nil {
+	return nil
+}
+
+func (w *Writer) TypeSignature(ts types.TypeSignature) error {
+	if err := w.Types(ts.Args); err != nil {
 		return err
 	}
-	for _, t := range ts.Ret {
-		if err := w.Type(t); err != nil {
-			return err
-		}
-	}
-	return nil
+	return w.Types(ts.Ret)
 }
@@ -0,0 +1,179 @@
+package govm
+
+import (
+	"./codegen"
+	"./types"
+	"errors"
+	"testing"
+)
+
+// newCallbackVM creates a VM with a builtin that calls a func(:int->int) on
+// each element of an array
+func newCallbackVM() VM {
+	v := New()
+	v.Builtin(codegen.Sig(":[]int:func(:int->int)->[]int"), func(c types.Caller, a ...types.Value) ([]types.Value, error) {
+		arr := a[0].(types.Array)
+		out := types.Array{types.TypeInt, make([]types.Value, len(arr.V))}
+		for i, x := range arr.V {
+			rets, err := c.CallValue(a[1], x)
+			if err != nil {
+				return nil, err
+			}
+			out.V[i] = rets[0]
+		}
+		return []types.Value{out}, nil
+	})
+	v.Set("Map:[]int:func(:int->int)->[]int")
+	return v
+}
+
+func TestCallback(t *testing.T) {
+	g := codegen.New()
+	// A closure which adds the variable n from the scope it was created in
+	g.Push(10)
+	g.Set("n")
+	end := new(int)
+	g.Func(codegen.Sig(":int->int"), end)
+	g.Get("n")
+	g.Add()
+	g.Label(end)
+	g.Set("addN:int->int")
+
+	g.Push(2)
+	g.Make(codegen.Typ("[]int"))
+	g.Dup()
+	g.Push(0)
+	g.Push(1)
+	g.Store()
+	g.Dup()
+	g.Push(1)
+	g.Push(2)
+	g.Store()
+	g.Get("addN:int->int")
+	g.Get("Map:[]int:func(:int->int)->[]int")
+	g.Call()
+	code, err := g.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v := newCallbackVM()
+	if err := v.Load(code); err != nil {
+		t.Fatal(err)
+	}
+	s := v.Stack()
+	if len(s) != 1 {
+		t.Fatal("Expected one value on the stack, got", s)
+	}
+	if arr := s[0].(types.Array); len(arr.V) != 2 || arr.V[0] != 11 || arr.V[1] != 12 {
+		t.Error("Expected [11 12], got", arr)
+	}
+}
+
+func TestCallbackErrors(t *testing.T) {
+	gen := func(body func(g *codegen.Generator)) []byte {
+		g := codegen.New()
+		end := new(int)
+		g.Func(codegen.Sig(":int->int"), end)
+		body(&g)
+		g.Label(end)
+		g.Set("f:int->int")
+
+		catch := new(int)
+		done := new(int)
+		g.Push(0)
+		g.Try(catch)
+		g.Push(1)
+		g.Make(codegen.Typ("[]int"))
+		g.Get("f:int->int")
+		g.Get("Map:[]int:func(:int->int)->[]int")
+		g.Call()
+		g.EndTry()
+		g.J(done)
+		g.Label(catch)
+		g.Set("caught")
+		g.Label(done)
+		code, err := g.Generate()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return code
+	}
+
+	// Errors thrown by the callback can be caught by the builtin's caller
+	code := gen(func(g *codegen.Generator) {
+		g.Pop()
+		g.Push("boom")
+		g.Get("Error:string->error")
+		g.Call()
+		g.Throw()
+	})
+	v := newCallbackVM()
+	if err := v.Load(code); err != nil {
+		t.Fatal(err)
+	}
+	if err := v.Get("caught"); err != nil {
+		t.Fatal("Expected the error to be caught:", err)
+	}
+	caught, _ := v.Pop()
+	if e, ok := caught.(types.Error); !ok || e.Err.Error() != "boom" {
+		t.Error("Expected to catch boom, got", caught)
+	}
+	if s := v.Stack(); len(s) != 1 || s[0] != 0 {
+		t.Error("Expected the stack to be restored to [0], got", s)
+	}
+
+	// Other errors can't be caught, and are returned with the frames of
+	// the callback
+	code = gen(func(g *codegen.Generator) {
+		g.Push(1)
+		g.Get("missing")
+	})
+	v = newCallbackVM()
+	err := v.Load(code)
+	var rerr *RuntimeError
+	if !errors.As(err, &rerr) || !errors.As(err, new(types.NameError)) {
+		t.Fatal("Expected runtime name error, got", err)
+	}
+	if len(rerr.Trace) != 2 || rerr.Trace[0].Func == nil || rerr.Trace[1].Func != nil {
+		t.Error("Expected trace of callback and top level, got", rerr.Trace)
+	}
+	if v.Get("caught") == nil {
+		t.Error("Expected name error not to be caught")
+	}
+
+	// Budget exhaustion in the callback stops the whole program
+	code = gen(func(g *codegen.Generator) {
+		loop := g.Label(nil)
+		g.J(loop)
+	})
+	v = newCallbackVM()
+	v.Budget = 1000
+	if err := v.Load(code); !errors.Is(err, ErrBudgetExhausted) {
+		t.Error("Expected budget exhausted, got", err)
+	}
+	if v.Get("caught") == nil {
+		t.Error("Expected budget exhaustion not to be caught")
+	}
+
+	// So does recursing too deeply through the builtin, though the call
+	// that overflows fails before running any of the callback's code
+	code = gen(func(g *codegen.Generator) {
+		g.Pop()
+		g.Push(1)
+		g.Make(codegen.Typ("[]int"))
+		g.Get("f:int->int")
+		g.Get("Map:[]int:func(:int->int)->[]int")
+		g.Call()
+		g.Push(0)
+		g.Index()
+	})
+	v = newCallbackVM()
+	v.MaxDepth = 5
+	if err := v.Load(code); !errors.As(err, new(types.StackOverflow)) {
+		t.Error("Expected stack overflow, got", err)
+	}
+	if v.Get("caught") == nil {
+		t.Error("Expected stack overflow not to be caught")
+	}
+}
@@ -0,0 +1,105 @@
+package govm
+
+import (
+	"./codegen"
+	"./types"
+	"testing"
+)
+
+func TestClosure(t *testing.T) {
+	g := codegen.New()
+	captureEnd := new(int)
+	g.Func(codegen.Sig(":int->func(:->int)"), captureEnd)
+	g.Set("x")
+	innerEnd := new(int)
+	g.Func(codegen.Sig(":->int"), innerEnd)
+	g.Get("x")
+	g.Label(innerEnd)
+	g.Label(captureEnd)
+	g.Set("capture:int->func(:->int)")
+
+	g.Push(1)
+	g.Get("capture:int->func(:->int)")
+	g.Call()
+	g.Set("f")
+
+	// With dynamic scoping, f would see this x instead of its own
+	g.Push(2)
+	g.Set("x")
+
+	code, err := g.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := New()
+	if err := v.Load(code); err != nil {
+		t.Fatal("Load:", err)
+	}
+
+	if err := v.Get("f"); err != nil {
+		t.Fatal(err)
+	}
+	if err := v.Call(); err != nil {
+		t.Fatal("Call:", err)
+	}
+	if x, _ := v.Pop(); x != 1 {
+		t.Error("Expected the captured value 1, got", x)
+	}
+}
+
+func TestClosureSet(t *testing.T) {
+	g := codegen.New()
+	g.Push(0)
+	g.Set("n")
+	end := new(int)
+	g.Func(codegen.Sig(":->int"), end)
+	g.Get("n")
+	g.Inc()
+	g.Set("n")
+	g.Get("n")
+	g.Label(end)
+	g.Set("count:->int")
+
+	code, err := g.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := New()
+	if err := v.Load(code); err != nil {
+		t.Fatal("Load:", err)
+	}
+
+	// Each call shadows n rather than changing the captured n
+	for i := 0; i < 2; i++ {
+		v.Get("count:->int")
+		if err := v.Call(); err != nil {
+			t.Fatal("Call:", err)
+		}
+		if n, _ := v.Pop(); n != 1 {
+			t.Error("Expected the call to see n = 1, got", n)
+		}
+	}
+	v.Get("n")
+	if n, _ := v.Pop(); n != 0 {
+		t.Error("Expected the captured n to still be 0, got", n)
+	}
+}
+
+func TestFuncTypes(t *testing.T) {
+	// Function values only have func types with the same signature
+	f := types.Function{codegen.Sig(":int->string"), 0, nil, nil, nil}
+	for _, test := range []struct {
+		typ string
+		ok  bool
+	}{
+		{"func(:int->string)", true},
+		{"func(:string->string)", false},
+		{"func(:int->int)", false},
+		{"func(:int)", false},
+		{"func(:->string)", false},
+	} {
+		if err := codegen.Typ(test.typ).TypeCheck(f); (err == nil) != test.ok {
+			t.Errorf("%s: expected ok %v, got %v", test.typ, test.ok, err)
+		}
+	}
+}
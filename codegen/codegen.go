@@ -13,37 +13,64 @@ type Instruction struct {
 	Operands []types.Value
 }
 
+// Locals is the number of local variable slots used by a function. It is
+// only read when the code is generated, so it may be updated after the
+// function's header, once all its locals are known.
+type Locals struct{ N int }
+
+// operand converts operands that are specific to the codegen package into
+// values that the bytecode package can write
+func operand(val types.Value) types.Value {
+	if l, ok := val.(*Locals); ok {
+		return l.N
+	}
+	return val
+}
+
 type Generator struct {
 	i []Instruction
 	size int // Length of bytecode so far
+	structs []types.StructDef // Struct table
+	pool *bytecode.Pool // Constants and symbols
+	pos types.Pos // Source position of the next instruction, if known
+	debug types.DebugInfo
 }
 
 func New() Generator {
-	return Generator{}
+	return Generator{nil, 0, nil, &bytecode.Pool{}, types.Pos{}, types.DebugInfo{}}
 }
 
+// GenerateTo writes a complete GVB file, including its header, constant pool,
+// symbol table and struct table
 func (g Generator) GenerateTo(w io.Writer) error {
-	bw := bytecode.NewWriter(w)
+	code := bytes.Buffer{}
+	bw := bytecode.NewWriter(&code)
 	for _, i := range g.i {
 		if err := bw.WriteByte(i.Opcode); err != nil {
 			return err
 		}
-		switch i.Opcode {
-		case opcode.Push: // This instruction's operand is typed
-			for _, v := range i.Operands {
-				if err := bw.TypedValue(v); err != nil {
-					return err
-				}
-			}
-		default:
-			for _, v := range i.Operands {
-				if err := bw.Value(v); err != nil {
-					return err
-				}
+		for _, v := range i.Operands {
+			if err := bw.Value(operand(v)); err != nil {
+				return err
 			}
 		}
 	}
-	return nil
+	f := bytecode.NewFile()
+	f.Sections[bytecode.CodeSection] = code.Bytes()
+	if err := g.pooled().AddTo(f); err != nil {
+		return err
+	}
+	if len(g.structs) > 0 {
+		if err := bytecode.AddStructs(f, g.structs); err != nil {
+			return err
+		}
+	}
+	if len(g.debug.Offsets) > 0 {
+		if err := bytecode.AddDebugInfo(f, &g.debug); err != nil {
+			return err
+		}
+	}
+	return bytecode.NewWriter(w).File(f)
 }
 
 func (g Generator) Generate() ([]byte, error) {
@@ -53,16 +80,22 @@ func (g Generator) Generate() ([]byte, error) {
 }
 
 func (g *Generator) Instr(code byte, operands... types.Value) {
+	if g.pos.Line > 0 {
+		g.debug.Add(g.size, g.pos)
+	}
 	g.i = append(g.i, Instruction{code, operands})
 	g.size++
 	for _, val := range operands {
-		g.size += bytecode.SizeOf(val)
-		if code == opcode.Push {
-			g.size += bytecode.SizeOfType(types.TypeOf(val)) // Typed operand
-		}
+		g.size += bytecode.SizeOf(operand(val))
 	}
 }
 
+// Pos sets the source position of the following instructions, which is
+// written to the debug info section
+func (g *Generator) Pos(p types.Pos) {
+	g.pos = p
+}
+
 func (g *Generator) Label(lbl *int) *int {
 	if lbl == nil {
 		lbl = new(int)
@@ -91,8 +124,16 @@ func (g *Generator) JNz(lbl *int) {
 	g.Instr(opcode.JNz, lbl)
 }
 
+// pooled returns the generator's pool, creating it for a zero Generator
+func (g *Generator) pooled() *bytecode.Pool {
+	if g.pool == nil {
+		g.pool = &bytecode.Pool{}
+	}
+	return g.pool
+}
+
 func (g *Generator) Push(val types.Value) {
-	g.Instr(opcode.Push, val)
+	g.Instr(opcode.Push, g.pooled().Constant(val))
 }
 
 func (g *Generator) Pop() {
@@ -108,11 +149,19 @@ func (g *Generator) Swp() {
 }
 
 func (g *Generator) Set(s string) {
-	g.Instr(opcode.Set, s)
+	g.Instr(opcode.Set, g.pooled().Symbol(types.Symbol(s)))
 }
 
 func (g *Generator) Get(s string) {
-	g.Instr(opcode.Get, s)
+	g.Instr(opcode.Get, g.pooled().Symbol(types.Symbol(s)))
+}
+
+func (g *Generator) LSet(n int) {
+	g.Instr(opcode.LSet, n)
+}
+
+func (g *Generator) LGet(n int) {
+	g.Instr(opcode.LGet, n)
 }
 
 func (g *Generator) Inc() {
@@ -231,6 +280,88 @@ func (g *Generator) Ret() {
 	g.Instr(opcode.Ret)
 }
 
-func (g *Generator) Func(ts types.TypeSignature, lbl *int) {
-	g.Instr(opcode.Func, ts, lbl)
+// Func begins a function, which ends at lbl. The returned Locals starts at 0
+// and should be set to the number of local variable slots the function uses.
+func (g *Generator) Func(ts types.TypeSignature, lbl *int) *Locals {
+	locals := new(Locals)
+	g.Instr(opcode.Func, ts, locals, lbl)
+	return locals
+}
+
+// Struct adds an entry to the struct table and returns its index. Its fields
+// may only refer to earlier entries. When the code is loaded, a struct with
+// a name uses an entry already in the VM's struct table with the same name
+// and fields, if there is one.
+func (g *Generator) Struct(name string, fields []types.Type) int {
+	g.structs = append(g.structs, types.StructDef{name, fields})
+	return len(g.structs) - 1
+}
+
+// Structs returns the struct table
+func (g *Generator) Structs() []types.StructDef {
+	return g.structs
+}
+
+func (g *Generator) New(i int) {
+	g.Instr(opcode.New, i)
+}
+
+func (g *Generator) FGet(n int) {
+	g.Instr(opcode.FGet, n)
+}
+
+func (g *Generator) FSet(n int) {
+	g.Instr(opcode.FSet, n)
+}
+
+func (g *Generator) Make(t types.Type) {
+	g.Instr(opcode.Make, t)
+}
+
+func (g *Generator) Index() {
+	g.Instr(opcode.Index)
+}
+
+func (g *Generator) Store() {
+	g.Instr(opcode.Store)
+}
+
+func (g *Generator) Len() {
+	g.Instr(opcode.Len)
+}
+
+func (g *Generator) Append() {
+	g.Instr(opcode.Append)
+}
+
+func (g *Generator) Slice() {
+	g.Instr(opcode.Slice)
+}
+
+func (g *Generator) Lookup() {
+	g.Instr(opcode.Lookup)
+}
+
+func (g *Generator) Delete() {
+	g.Instr(opcode.Delete)
+}
+
+func (g *Generator) Keys() {
+	g.Instr(opcode.Keys)
+}
+
+func (g *Generator) SKeys() {
+	g.Instr(opcode.SKeys)
+}
+
+func (g *Generator) Throw() {
+	g.Instr(opcode.Throw)
+}
+
+func (g *Generator) Try(catch *int) {
+	g.Instr(opcode.Try, catch)
+}
+
+func (g *Generator) EndTry() {
+	g.Instr(opcode.EndTry)
 }
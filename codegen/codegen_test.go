@@ -2,6 +2,7 @@ package codegen
 
 import (
 	"testing"
+	"../bytecode"
 	"../opcode"
 	"../types"
 )
@@ -22,23 +23,48 @@ func TestCodegen(t *testing.T) {
 	}
 
 	// Expected result
-	code := []byte{
+	code := []byte(bytecode.Magic)
+	code = append(code,
+		0x01, 0x00, // Version 1.0
+		0x00, 0x00, // No flags
+		0x00, 0x00, 0x00, 0x03, // 3 sections
+		byte(bytecode.CodeSection),
+		0x00, 0x00, 0x00, 0x27, // Code starts at byte 39
+		0x00, 0x00, 0x00, 0x21, // 33 bytes of code
+		byte(bytecode.ConstantSection),
+		0x00, 0x00, 0x00, 0x48, // Constants start at byte 72
+		0x00, 0x00, 0x00, 0x16, // 22 bytes of constants
+		byte(bytecode.SymbolSection),
+		0x00, 0x00, 0x00, 0x5e, // Symbols start at byte 94
+		0x00, 0x00, 0x00, 0x1e, // 30 bytes of symbols
+
+		// Code
 		byte(opcode.Func),
 		0x00, 0x00, 0x00, 0x00, // 0 args
 		0x00, 0x00, 0x00, 0x00, // 0 return values
-		0x00, 0x00, 0x00, 0x27, // 39 bytes
-		byte(opcode.Push), byte(types.String),
+		0x00, 0x00, 0x00, 0x00, // 0 locals
+		0x00, 0x00, 0x00, 0x0b, // 11 bytes
+		byte(opcode.Push),
+		0x00, 0x00, 0x00, 0x00, // Constant 0
+		byte(opcode.Get),
+		0x00, 0x00, 0x00, 0x00, // Symbol 0
+		byte(opcode.Call),
+		byte(opcode.Set),
+		0x00, 0x00, 0x00, 0x01, // Symbol 1
+
+		// Constants
+		0x00, 0x00, 0x00, 0x01, // 1 constant
+		byte(types.String),
 		0x00, 0x00, 0x00, 0x0d, // 13 bytes
-	}
+	)
 	code = append(code, []byte("Hello, world!")...)
 	code = append(code,
-		byte(opcode.Get),
+		// Symbols
+		0x00, 0x00, 0x00, 0x02, // 2 symbols
 		0x00, 0x00, 0x00, 0x0e, // 14 bytes
 	)
 	code = append(code, []byte("Println:string")...)
 	code = append(code,
-		byte(opcode.Call),
-		byte(opcode.Set),
 		0x00, 0x00, 0x00, 0x04, // 4 bytes
 	)
 	code = append(code, []byte("Main")...)
@@ -2,10 +2,26 @@ package codegen
 
 import (
 	"../types"
+	"strconv"
 	"strings"
 )
 
-func Typ(s string) (t types.Type) {
+// Names maps struct names to their index in the struct table
+type Names map[string]int
+
+func Typ(s string) types.Type {
+	return Names(nil).Typ(s)
+}
+
+func Types(s string) []types.Type {
+	return Names(nil).Types(s)
+}
+
+func Sig(s string) types.TypeSignature {
+	return Names(nil).Sig(s)
+}
+
+func (n Names) Typ(s string) (t types.Type) {
 	switch s {
 	case "int":
 		return types.TypeInt
@@ -15,28 +31,88 @@ func Typ(s string) (t types.Type) {
 		return types.TypeBool
 	case "string":
 		return types.TypeString
+	case "error":
+		return types.TypeErr
+	}
+	if strings.HasPrefix(s, "[]") {
+		elem := n.Typ(s[2:])
+		return types.Type{types.ArrayT, types.TypeSignature{}, 0, &elem, nil}
+	}
+	if strings.HasPrefix(s, "map[") {
+		// The key can't contain brackets, as it must be comparable
+		if end := strings.IndexByte(s, ']'); end > 0 {
+			key := n.Typ(s[4:end])
+			elem := n.Typ(s[end+1:])
+			return types.Type{types.MapT, types.TypeSignature{}, 0, &elem, &key}
+		}
+	}
+	if i, ok := n[s]; ok {
+		return types.Type{types.Struct, types.TypeSignature{}, i, nil, nil}
+	}
+	if strings.HasPrefix(s, "struct(") && strings.HasSuffix(s, ")") {
+		i, err := strconv.Atoi(strings.TrimPrefix(strings.TrimSuffix(s, ")"), "struct("))
+		if err == nil && i >= 0 {
+			return types.Type{types.Struct, types.TypeSignature{}, i, nil, nil}
+		}
 	}
 	if strings.HasPrefix(s, "func(") && strings.HasSuffix(s, ")") {
 		t := types.TypeFunc
-		t.Sig = Sig(strings.TrimPrefix(strings.TrimSuffix(s, ")"), "func("))
+		t.Sig = n.Sig(strings.TrimPrefix(strings.TrimSuffix(s, ")"), "func("))
 		return t
 	}
 	panic("Unknown type")
 }
 
-func Sig(s string) (ts types.TypeSignature) {
+// Types parses a colon-separated list of types, such as the fields of a struct
+func (n Names) Types(s string) (ts []types.Type) {
 	s = strings.TrimPrefix(s, ":")
 	if s == "" {
 		return
 	}
-	sections := strings.SplitN(s, "->", 2)
-	for _, a := range strings.Split(sections[0], ":") {
-		ts.Args = append(ts.Args, Typ(a))
+	for _, t := range Split(s, ":") {
+		ts = append(ts, n.Typ(t))
 	}
-	if len(sections) > 1 {
-		for _, r := range strings.Split(sections[1], ":") {
-			ts.Ret = append(ts.Ret, Typ(r))
+	return
+}
+
+// Split is like strings.Split, but ignores separators inside brackets, such as
+// those in the signature of a function type
+func Split(s, sep string) (parts []string) {
+	depth := 0
+	start := 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '(', '[':
+			depth++
+		case ')', ']':
+			depth--
+		default:
+			if depth == 0 && strings.HasPrefix(s[i:], sep) {
+				parts = append(parts, s[start:i])
+				i += len(sep) - 1
+				start = i + 1
+			}
 		}
 	}
+	return append(parts, s[start:])
+}
+
+func (n Names) Sig(s string) (ts types.TypeSignature) {
+	s = strings.TrimPrefix(s, ":")
+	if s == "" {
+		return
+	}
+	sections := Split(s, "->")
+	ts.Args = n.Types(sections[0])
+	if len(sections) > 1 {
+		ts.Ret = n.Types(sections[1])
+	}
 	return
 }
+
+// Mangle returns the symbol of a variable called name holding a function
+// with signature sig, such as "ToString:int->string". It is the inverse of
+// splitting a symbol at its first colon and parsing the rest with Sig.
+func Mangle(name string, sig types.TypeSignature) types.Symbol {
+	return types.Symbol(name + ":" + strings.TrimPrefix(sig.String(), ":"))
+}
@@ -0,0 +1,244 @@
+package govm
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"./opcode"
+	"./types"
+)
+
+// Coverage records which instructions of the code loaded into a VM are
+// executed, and which way each conditional jump goes. A Coverage is attached
+// to a VM by setting its Coverage field before code is loaded.
+type Coverage struct {
+	Funcs  []*FuncCoverage // Functions in the order they were loaded
+	instrs map[*types.Instruction]*InstrCoverage
+}
+
+// FuncCoverage is the coverage of the instructions of a function, or of the
+// top level of a file
+type FuncCoverage struct {
+	Name   string
+	Instrs []*InstrCoverage
+}
+
+// InstrCoverage is the coverage of a single instruction
+type InstrCoverage struct {
+	Offset          int
+	Pos             types.Pos // Position of the instruction in its source, if the code has debug info
+	Branch          bool      // Whether the instruction is a conditional jump
+	Count           int       // Number of times the instruction was executed
+	Taken, NotTaken int       // Number of times a conditional jump was taken and not taken
+}
+
+// NewCoverage creates an empty coverage
+func NewCoverage() *Coverage {
+	return &Coverage{nil, make(map[*types.Instruction]*InstrCoverage)}
+}
+
+// add adds the instructions in code, and the bodies of any functions it
+// creates. Functions are named by the variable they are stored in
+// immediately after being created.
+func (c *Coverage) add(name string, code []types.Instruction, debug *types.DebugInfo) {
+	f := &FuncCoverage{name, nil}
+	c.Funcs = append(c.Funcs, f)
+	for i := range code {
+		in := &code[i]
+		ic := &InstrCoverage{Offset: in.Offset}
+		ic.Pos, _ = debug.Lookup(in.Offset)
+		switch in.Op {
+		case opcode.JT, opcode.JF, opcode.JZ, opcode.JNz:
+			ic.Branch = true
+		}
+		f.Instrs = append(f.Instrs, ic)
+		c.instrs[in] = ic
+
+		if in.Op == opcode.Func {
+			fn := in.Val.(types.Function)
+			name := fmt.Sprintf("func(%s)@%#x", fn.Sig, in.Offset)
+			if i+1 < len(code) && code[i+1].Op == opcode.Set {
+				name = string(code[i+1].Val.(types.Symbol))
+			}
+			c.add(name, fn.Code, fn.Debug)
+		}
+	}
+}
+
+// hit is called before in is executed
+func (c *Coverage) hit(in *types.Instruction) {
+	if ic, ok := c.instrs[in]; ok {
+		ic.Count++
+	}
+}
+
+// branch is called after the conditional jump in is executed
+func (c *Coverage) branch(in *types.Instruction, taken bool) {
+	if ic, ok := c.instrs[in]; ok {
+		if taken {
+			ic.Taken++
+		} else {
+			ic.NotTaken++
+		}
+	}
+}
+
+// Instructions returns the number of instructions in f that were executed
+// and the total number of instructions
+func (f *FuncCoverage) Instructions() (covered, total int) {
+	for _, ic := range f.Instrs {
+		if ic.Count > 0 {
+			covered++
+		}
+	}
+	return covered, len(f.Instrs)
+}
+
+// Branches returns the number of directions of conditional jumps in f that
+// were followed, and the total number of directions. Each conditional jump
+// has two directions, taken and not taken.
+func (f *FuncCoverage) Branches() (covered, total int) {
+	for _, ic := range f.Instrs {
+		if !ic.Branch {
+			continue
+		}
+		total += 2
+		if ic.Taken > 0 {
+			covered++
+		}
+		if ic.NotTaken > 0 {
+			covered++
+		}
+	}
+	return covered, total
+}
+
+// The first line of a coverage profile
+const coverageHeader = "gvcover 1"
+
+// WriteTo writes the coverage as a text profile, which can be read back with
+// ReadCoverage. Each function starts with a line "func NAME", followed by a
+// line for each instruction with its offset, position, count, and for
+// conditional jumps, the number of times it was taken and not taken. Fields
+// are separated by tabs.
+func (c *Coverage) WriteTo(w io.Writer) (int64, error) {
+	var b bytes.Buffer
+	fmt.Fprintln(&b, coverageHeader)
+	for _, f := range c.Funcs {
+		fmt.Fprintf(&b, "func\t%s\n", f.Name)
+		for _, ic := range f.Instrs {
+			pos := "-"
+			if ic.Pos.Line > 0 {
+				pos = ic.Pos.String()
+			}
+			fmt.Fprintf(&b, "%#x\t%s\t%d", ic.Offset, pos, ic.Count)
+			if ic.Branch {
+				fmt.Fprintf(&b, "\t%d\t%d", ic.Taken, ic.NotTaken)
+			}
+			fmt.Fprintln(&b)
+		}
+	}
+	return b.WriteTo(w)
+}
+
+type CoverageError struct {
+	Line   int
+	Reason string
+}
+
+func (e CoverageError) Error() string {
+	return fmt.Sprintf("Invalid coverage profile: line %d: %s", e.Line, e.Reason)
+}
+
+// ReadCoverage reads a profile written by Coverage.WriteTo
+func ReadCoverage(r io.Reader) (*Coverage, error) {
+	c := NewCoverage()
+	s := bufio.NewScanner(r)
+	line := 0
+	for s.Scan() {
+		line++
+		text := s.Text()
+		if line == 1 {
+			if text != coverageHeader {
+				return nil, CoverageError{line, "missing header"}
+			}
+			continue
+		}
+		fields := strings.Split(text, "\t")
+		if fields[0] == "func" && len(fields) == 2 {
+			c.Funcs = append(c.Funcs, &FuncCoverage{fields[1], nil})
+			continue
+		}
+		if len(c.Funcs) == 0 {
+			return nil, CoverageError{line, "instruction outside a function"}
+		}
+		ic, err := parseInstrCoverage(fields)
+		if err != nil {
+			return nil, CoverageError{line, err.Error()}
+		}
+		f := c.Funcs[len(c.Funcs)-1]
+		f.Instrs = append(f.Instrs, ic)
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	if line == 0 {
+		return nil, CoverageError{line, "missing header"}
+	}
+	return c, nil
+}
+
+func parseInstrCoverage(fields []string) (*InstrCoverage, error) {
+	if len(fields) != 3 && len(fields) != 5 {
+		return nil, fmt.Errorf("expected 3 or 5 fields, got %d", len(fields))
+	}
+	ic := &InstrCoverage{Branch: len(fields) == 5}
+	var err error
+	if ic.Offset, err = parseInt(fields[0]); err != nil {
+		return nil, err
+	}
+	if fields[1] != "-" {
+		if ic.Pos, err = parsePos(fields[1]); err != nil {
+			return nil, err
+		}
+	}
+	if ic.Count, err = parseInt(fields[2]); err != nil {
+		return nil, err
+	}
+	if ic.Branch {
+		if ic.Taken, err = parseInt(fields[3]); err != nil {
+			return nil, err
+		}
+		if ic.NotTaken, err = parseInt(fields[4]); err != nil {
+			return nil, err
+		}
+	}
+	return ic, nil
+}
+
+func parseInt(s string) (int, error) {
+	i, err := strconv.ParseInt(s, 0, 0)
+	return int(i), err
+}
+
+// parsePos parses a position formatted by types.Pos.String. The file name
+// may itself contain colons.
+func parsePos(s string) (types.Pos, error) {
+	var pos types.Pos
+	parts := strings.Split(s, ":")
+	if len(parts) < 2 {
+		return pos, fmt.Errorf("invalid position %q", s)
+	}
+	var err error
+	if pos.Col, err = strconv.Atoi(parts[len(parts)-1]); err != nil {
+		return pos, fmt.Errorf("invalid position %q", s)
+	}
+	if pos.Line, err = strconv.Atoi(parts[len(parts)-2]); err != nil {
+		return pos, fmt.Errorf("invalid position %q", s)
+	}
+	pos.File = strings.Join(parts[:len(parts)-2], ":")
+	return pos, nil
+}
@@ -0,0 +1,141 @@
+package govm
+
+import (
+	"./codegen"
+	"./types"
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestCoverage(t *testing.T) {
+	g := codegen.New()
+	end := new(int)
+	g.Func(codegen.Sig(":bool->int"), end)
+	skip := new(int)
+	g.JF(skip)
+	g.Push(1)
+	g.Ret()
+	g.Label(skip)
+	g.Push(2)
+	g.Label(end)
+	g.Set("f:bool->int")
+	g.Push(true)
+	g.Get("f:bool->int")
+	g.Call()
+	code, err := g.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v := New()
+	v.Coverage = NewCoverage()
+	if err := v.Load(code); err != nil {
+		t.Fatal(err)
+	}
+	c := v.Coverage
+	if len(c.Funcs) != 2 || c.Funcs[0].Name != "<toplevel>" || c.Funcs[1].Name != "f:bool->int" {
+		t.Fatal("Unexpected functions", c.Funcs)
+	}
+	top, f := c.Funcs[0], c.Funcs[1]
+	if covered, total := top.Instructions(); covered != 5 || total != 5 {
+		t.Errorf("Expected 5/5 top level instructions covered, got %d/%d", covered, total)
+	}
+	if covered, total := f.Instructions(); covered != 3 || total != 4 {
+		t.Errorf("Expected 3/4 instructions of f covered, got %d/%d", covered, total)
+	}
+	if covered, total := f.Branches(); covered != 1 || total != 2 {
+		t.Errorf("Expected 1/2 branches of f covered, got %d/%d", covered, total)
+	}
+	if jf := f.Instrs[0]; !jf.Branch || jf.Count != 1 || jf.Taken != 0 || jf.NotTaken != 1 {
+		t.Error("Unexpected coverage of jf", *jf)
+	}
+
+	// Running the function again adds to the counts
+	v.Push(false)
+	v.Get("f:bool->int")
+	if err := v.Call(); err != nil {
+		t.Fatal(err)
+	}
+	if covered, _ := f.Branches(); covered != 2 {
+		t.Error("Expected both branches of f to be covered, got", covered)
+	}
+
+	var buf bytes.Buffer
+	if _, err := c.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	read, err := ReadCoverage(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(read.Funcs, c.Funcs) {
+		t.Error("Coverage changed when written and read back")
+	}
+}
+
+func TestCoverageBranchToNext(t *testing.T) {
+	// Each jump's target is the next instruction, so whether it was taken
+	// can't be told from where execution continued
+	g := codegen.New()
+	tests := []struct {
+		jump  func(*int)
+		val   types.Value
+		taken bool
+	}{
+		{g.JT, true, true},
+		{g.JT, false, false},
+		{g.JF, false, true},
+		{g.JF, true, false},
+		{g.JZ, 0, true},
+		{g.JZ, 1.5, false},
+		{g.JNz, 1, true},
+		{g.JNz, 0.0, false},
+	}
+	for _, test := range tests {
+		g.Push(test.val)
+		next := new(int)
+		test.jump(next)
+		g.Label(next)
+	}
+	code, err := g.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v := New()
+	v.Coverage = NewCoverage()
+	if err := v.Load(code); err != nil {
+		t.Fatal(err)
+	}
+	for i, test := range tests {
+		in := v.Coverage.Funcs[0].Instrs[2*i+1]
+		if in.Taken+in.NotTaken != 1 || (in.Taken == 1) != test.taken {
+			t.Errorf("Jump %d on %v: expected taken %v, got %d taken and %d not taken", i, test.val, test.taken, in.Taken, in.NotTaken)
+		}
+	}
+}
+
+func TestCoveragePositions(t *testing.T) {
+	c := &Coverage{[]*FuncCoverage{{"f", []*InstrCoverage{
+		{0, types.Pos{"a:b.gva", 3, 4}, true, 2, 1, 1},
+		{5, types.Pos{"", 1, 1}, false, 0, 0, 0},
+	}}}, nil}
+	var buf bytes.Buffer
+	if _, err := c.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	read, err := ReadCoverage(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(read.Funcs, c.Funcs) {
+		t.Error("Coverage changed when written and read back:", buf.String())
+	}
+
+	for _, s := range []string{"", "gvcover 2\n", "gvcover 1\n0x0\t-\t1\n", "gvcover 1\nfunc\tf\n0x0\t1:x\t1\n"} {
+		if _, err := ReadCoverage(bytes.NewBufferString(s)); err == nil {
+			t.Errorf("Expected error reading %q", s)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package govm
+
+import (
+	"./asm"
+	"./types"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestDebugInfo(t *testing.T) {
+	src := `func :int->int
+	push "not an int"
+	add
+endfunc
+set @f:int->int
+
+push 1
+get @f:int->int
+call
+`
+	g, err := asm.AssembleDebug(strings.NewReader(src), "test.gva")
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, err := g.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := New()
+	err = v.Load(code)
+
+	var rerr *RuntimeError
+	if !errors.As(err, &rerr) {
+		t.Fatal("Expected runtime error, got", err)
+	}
+	if len(rerr.Trace) != 2 {
+		t.Fatal("Expected 2 frames in trace, got", rerr.Trace)
+	}
+	if p := rerr.Trace[0].Pos; p != (types.Pos{"test.gva", 3, 2}) {
+		t.Error("Expected the add to be at test.gva:3:2, got", p)
+	}
+	if p := rerr.Trace[1].Pos; p != (types.Pos{"test.gva", 9, 1}) {
+		t.Error("Expected the call to be at test.gva:9:1, got", p)
+	}
+	if !strings.HasPrefix(err.Error(), "test.gva:3:2: Type error") {
+		t.Error("Expected the error to start with its position, got", err)
+	}
+
+	// Without debug info, there are no positions
+	g, err = asm.Assemble(strings.NewReader(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code, err = g.Generate(); err != nil {
+		t.Fatal(err)
+	}
+	if err := v.Load(code); !errors.As(err, &rerr) || rerr.Trace[0].Pos.Line != 0 {
+		t.Error("Expected runtime error without position, got", err)
+	}
+}
@@ -0,0 +1,69 @@
+package govm
+
+import (
+	"errors"
+	"fmt"
+	"./bytecode"
+	"./types"
+)
+
+type InvalidOpcodeError = bytecode.InvalidOpcodeError
+
+// ErrBudgetExhausted is returned when more instructions than allowed by the
+// VM's Budget are executed
+var ErrBudgetExhausted = errors.New("Budget exhausted")
+
+type NoTryError struct{}
+
+func (e NoTryError) Error() string {
+	return "Try error: endtry without matching try"
+}
+
+// Frame is an entry in a stack trace
+type Frame struct {
+	Func   *types.Function // nil for code run directly by Load or LoadFrom
+	Offset int             // Offset of the instruction in the code passed to Load
+	Pos    types.Pos       // Position of the instruction in its source, if the code has debug info
+}
+
+func (f Frame) String() string {
+	s := fmt.Sprintf("<toplevel>+%#x", f.Offset)
+	if f.Func != nil {
+		s = fmt.Sprintf("func(%s)+%#x", f.Func.Sig, f.Offset)
+	}
+	if f.Pos.Line > 0 {
+		s += " (" + f.Pos.String() + ")"
+	}
+	return s
+}
+
+// RuntimeError wraps an error returned during execution with the stack of
+// frames that were active when it occurred, innermost first
+type RuntimeError struct {
+	Err   error
+	Trace []Frame
+}
+
+// The number of frames shown at each end of a long trace
+const traceEnds = 10
+
+func (e *RuntimeError) Error() string {
+	s := e.Err.Error()
+	if len(e.Trace) > 0 && e.Trace[0].Pos.Line > 0 {
+		s = e.Trace[0].Pos.String() + ": " + s
+	}
+	for i, f := range e.Trace {
+		if len(e.Trace) > 2*traceEnds && i == traceEnds {
+			s += fmt.Sprintf("\n\t... %d more frames", len(e.Trace)-2*traceEnds)
+		}
+		if len(e.Trace) > 2*traceEnds && i >= traceEnds && i < len(e.Trace)-traceEnds {
+			continue
+		}
+		s += "\n\tat " + f.String()
+	}
+	return s
+}
+
+func (e *RuntimeError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,129 @@
+package govm
+
+import (
+	"./bytecode"
+	"./codegen"
+	"./opcode"
+	"./types"
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestInvalidOpcode(t *testing.T) {
+	v := New()
+	err := v.Load([]byte{byte(opcode.Push), byte(types.Int), 0, 0, 0, 1, 0xff})
+	var oerr InvalidOpcodeError
+	if !errors.As(err, &oerr) {
+		t.Fatal("Expected invalid opcode error, got", err)
+	}
+	if oerr.Offset != 6 || oerr.Byte != 0xff {
+		t.Error("Wrong offset or byte in error:", oerr)
+	}
+}
+
+func TestTrace(t *testing.T) {
+	g := codegen.New()
+	end := new(int)
+	g.Func(codegen.Sig(":int"), end)
+	g.Push("not an int")
+	g.Add()
+	g.Label(end)
+	g.Set("f:int")
+	g.Push(1)
+	g.Get("f:int")
+	g.Call()
+
+	code, err := g.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := New()
+	err = v.Load(code)
+
+	var rerr *RuntimeError
+	if !errors.As(err, &rerr) {
+		t.Fatal("Expected runtime error, got", err)
+	}
+	if !errors.As(err, new(types.TypeError)) {
+		t.Error("Expected the runtime error to wrap a type error, got", rerr.Err)
+	}
+	if len(rerr.Trace) != 2 {
+		t.Fatal("Expected 2 frames in trace, got", rerr.Trace)
+	}
+	// 18 bytes of func header, then 5 bytes of push
+	if f := rerr.Trace[0]; f.Func == nil || f.Offset != 23 {
+		t.Error("Expected the innermost frame to be the add in f, got", f)
+	}
+	if f := rerr.Trace[1]; f.Func != nil {
+		t.Error("Expected the outermost frame to be the top level, got", f)
+	}
+}
+
+func TestInvalidJump(t *testing.T) {
+	v := New()
+	// Jumps into the middle of the push
+	err := v.Load([]byte{byte(opcode.J), 0, 0, 0, 1, byte(opcode.Push), byte(types.Int), 0, 0, 0, 1})
+	var jerr bytecode.JumpError
+	if !errors.As(err, &jerr) {
+		t.Fatal("Expected jump error, got", err)
+	}
+	if jerr.Offset != 0 || jerr.Target != 6 {
+		t.Error("Wrong offset or target in error:", jerr)
+	}
+}
+
+func TestMalformed(t *testing.T) {
+	// A file with an empty code section and the given sections, which
+	// alternate between a Section and its data
+	withSections := func(sections ...interface{}) []byte {
+		f := bytecode.NewFile()
+		f.Sections[bytecode.CodeSection] = nil
+		for i := 0; i < len(sections); i += 2 {
+			f.Sections[sections[i].(bytecode.Section)] = sections[i+1].([]byte)
+		}
+		buf := bytes.Buffer{}
+		if err := bytecode.NewWriter(&buf).File(f); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+	withStructs := func(structs ...byte) []byte {
+		return withSections(bytecode.StructSection, structs)
+	}
+	minus1 := []byte{0xff, 0xff, 0xff, 0xff}
+	zero := []byte{0, 0, 0, 0}
+	header := append([]byte(bytecode.Magic), bytecode.Major, bytecode.Minor, 0, 0)
+
+	for _, test := range []struct {
+		name string
+		code []byte
+		err  interface{}
+	}{
+		{"func with -1 args", []byte{byte(opcode.Func), 0xff, 0xff, 0xff, 0xff}, new(bytecode.LengthError)},
+		{"func with too many args", []byte{byte(opcode.Func), 0x7f, 0xff, 0xff, 0xff}, new(bytecode.LengthError)},
+		{"func body of -1 bytes", []byte{byte(opcode.Func), 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0xff, 0xff, 0xff, 0xff}, new(bytecode.LengthError)},
+		{"string of -1 bytes", []byte{byte(opcode.Get), 0xff, 0xff, 0xff, 0xff}, new(bytecode.LengthError)},
+		{"func with -1 locals", []byte{byte(opcode.Func), 0, 0, 0, 0, 0, 0, 0, 0, 0xff, 0xff, 0xff, 0xff, 0, 0, 0, 0, byte(opcode.Call)}, new(bytecode.LengthError)},
+		{"func with too many locals", []byte{byte(opcode.Func), 0, 0, 0, 0, 0, 0, 0, 0, 0x7f, 0xff, 0xff, 0xff, 0, 0, 0, 0, byte(opcode.Call)}, new(bytecode.LengthError)},
+		{"removed struct opcode", []byte{0x60, 0xff, 0xff, 0xff, 0xff}, new(InvalidOpcodeError)},
+		{"-1 structs", withStructs(0xff, 0xff, 0xff, 0xff), new(bytecode.SectionError)},
+		{"-1 sections", append(header, minus1...), new(bytecode.LengthError)},
+		{"-1 constants", withSections(bytecode.ConstantSection, minus1, bytecode.SymbolSection, zero), new(bytecode.SectionError)},
+		{"too many constants", withSections(bytecode.ConstantSection, []byte{0, 0, 0, 1}, bytecode.SymbolSection, zero), new(bytecode.SectionError)},
+		{"-1 symbols", withSections(bytecode.ConstantSection, zero, bytecode.SymbolSection, minus1), new(bytecode.SectionError)},
+		{"-1 debug files", withSections(bytecode.DebugSection, minus1), new(bytecode.SectionError)},
+		{"-1 debug entries", withSections(bytecode.DebugSection, append(zero, minus1...)), new(bytecode.SectionError)},
+		{"too many debug entries", withSections(bytecode.DebugSection, append(zero, 0, 0, 0, 1)), new(bytecode.SectionError)},
+		{"struct with -1 fields", withStructs(0, 0, 0, 1, 0, 0, 0, 0, 0xff, 0xff, 0xff, 0xff), new(bytecode.SectionError)},
+	} {
+		// The verifier mustn't see malformed code either
+		for _, verify := range []bool{false, true} {
+			v := New()
+			v.Verify = verify
+			if err := v.Load(test.code); !errors.As(err, test.err) {
+				t.Errorf("%s: expected %T, got %v", test.name, test.err, err)
+			}
+		}
+	}
+}
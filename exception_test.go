@@ -0,0 +1,104 @@
+package govm
+
+import (
+	"./codegen"
+	"./types"
+	"errors"
+	"testing"
+)
+
+func TestException(t *testing.T) {
+	g := codegen.New()
+	end := new(int)
+	g.Func(codegen.Sig(":string->int"), end)
+	g.Get("ToInt:string->int:error")
+	g.Call()
+	g.Throw()
+	g.Label(end)
+	g.Set("f:string->int")
+
+	catch := new(int)
+	done := new(int)
+	g.Push(1)
+	g.Try(catch)
+	g.Push(2)
+	g.Push("not an int")
+	g.Get("f:string->int")
+	g.Call()
+	g.EndTry()
+	g.J(done)
+	g.Label(catch)
+	g.Set("caught")
+	g.Label(done)
+	g.Set("one")
+
+	code, err := g.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := New()
+	if err := v.Load(code); err != nil {
+		t.Fatal("Load:", err)
+	}
+
+	if err := v.Get("caught"); err != nil {
+		t.Fatal(err)
+	}
+	caught, _ := v.Pop()
+	if e, ok := caught.(types.Error); !ok || e.Err == nil {
+		t.Error("Expected to catch an error, got", caught)
+	}
+	// The stack should be restored to its size when try was executed
+	v.Get("one")
+	if one, _ := v.Pop(); one != 1 {
+		t.Error("Expected 1 to be left on the stack, got", one)
+	}
+	if len(v.stack) != 0 {
+		t.Error("Expected empty stack, got", v.stack)
+	}
+
+	// Uncaught errors are returned
+	v.Push("x")
+	v.Get("f:string->int")
+	if err := v.Call(); !errors.As(err, new(types.Error)) {
+		t.Error("Expected uncaught error, got", err)
+	}
+}
+
+func TestUnclosedTry(t *testing.T) {
+	// A try region left open by one file doesn't catch errors in the next
+	g := codegen.New()
+	catch := new(int)
+	done := new(int)
+	g.Try(catch)
+	g.Push(1)
+	g.J(done)
+	g.Label(catch)
+	g.Push(99)
+	g.Label(done)
+	open, err := g.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	g = codegen.New()
+	g.Push("boom")
+	g.Get("Error:string->error")
+	g.Call()
+	g.Throw()
+	throw, err := g.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v := New()
+	if err := v.Load(open); err != nil {
+		t.Fatal("Load:", err)
+	}
+	if err := v.Load(throw); !errors.As(err, new(types.Error)) {
+		t.Error("Expected uncaught error, got", err)
+	}
+	if s := v.Stack(); len(s) != 1 || s[0] != 1 {
+		t.Error("Expected stack [1], got", s)
+	}
+}
@@ -0,0 +1,95 @@
+package govm
+
+import (
+	"./bytecode"
+	"./codegen"
+	"./opcode"
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestFileHeader(t *testing.T) {
+	g := codegen.New()
+	g.Push(1)
+	g.Set("x")
+	code, err := g.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := bytecode.ParseFile(code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Legacy || f.Major != bytecode.Major || f.Minor != bytecode.Minor {
+		t.Error("Wrong version:", f)
+	}
+
+	// Headerless code is loaded in legacy mode, with inline operands
+	legacy := bytes.Buffer{}
+	w := bytecode.NewWriter(&legacy)
+	w.WriteByte(opcode.Push)
+	w.TypedValue(2)
+	w.WriteByte(opcode.Set)
+	w.String("x")
+	v := New()
+	if err := v.Load(legacy.Bytes()); err != nil {
+		t.Error("Load legacy:", err)
+	}
+	if x, err := v.scope.Get("x"); err != nil || x != 2 {
+		t.Error("Expected x = 2, got", x, err)
+	}
+	if err := v.Load(code); err != nil {
+		t.Error("Load:", err)
+	}
+	if x, err := v.scope.Get("x"); err != nil || x != 1 {
+		t.Error("Expected x = 1, got", x, err)
+	}
+
+	// Unsupported major version
+	bad := append([]byte(nil), code...)
+	bad[len(bytecode.Magic)] = bytecode.Major + 1
+	if err := v.Load(bad); !errors.As(err, new(bytecode.VersionError)) {
+		t.Error("Expected version error, got", err)
+	}
+
+	// Truncated
+	if err := v.Load(code[:bytecode.HeaderSize(1)-1]); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Error("Expected unexpected EOF, got", err)
+	}
+	if err := v.Load(code[:len(code)-1]); !errors.As(err, new(bytecode.SectionError)) {
+		t.Error("Expected section error, got", err)
+	}
+}
+
+func TestPool(t *testing.T) {
+	g := codegen.New()
+	for i := 0; i < 3; i++ {
+		g.Push("hello")
+		g.Set("x")
+	}
+	g.Push(1)
+	g.Push(1.0)
+	code, err := g.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := bytecode.ParseFile(code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := bytecode.ReadPool(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p.Constants) != 3 || len(p.Symbols) != 1 {
+		t.Error("Expected 3 constants and 1 symbol, got", p.Constants, p.Symbols)
+	}
+
+	// Out of range constant
+	f.Sections[bytecode.CodeSection] = []byte{opcode.Push, 0x00, 0x00, 0x00, 0x03}
+	if _, _, err := bytecode.DecodeFile(f, nil); !errors.As(err, new(bytecode.PoolError)) {
+		t.Error("Expected pool error, got", err)
+	}
+}
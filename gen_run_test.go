@@ -6,7 +6,8 @@ import (
 	"testing"
 )
 
-func TestGenRun(t *testing.T) {
+// fizzbuzz generates the same code as examples/fizzbuzz.gva
+func fizzbuzz() codegen.Generator {
 	// This will be eaiser to read when compared line-by-line to fizzbuzz.gvm
 
 	g := codegen.New()
@@ -70,7 +71,11 @@ func TestGenRun(t *testing.T) {
 	g.Label(endLoop)
 	g.Label(mainEnd)
 	g.Set("Main:")
+	return g
+}
 
+func TestGenRun(t *testing.T) {
+	g := fizzbuzz()
 	code, err := g.Generate()
 	if err != nil {
 		t.Fatal(err)
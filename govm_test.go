@@ -12,6 +12,7 @@ func TestGovm(t *testing.T) {
 		byte(opcode.Func),
 		0x00, 0x00, 0x00, 0x00, // 0 args
 		0x00, 0x00, 0x00, 0x00, // 0 return values
+		0x00, 0x00, 0x00, 0x00, // 0 locals
 		0x00, 0x00, 0x00, 0x27, // 39 bytes
 		byte(opcode.Push), byte(types.String),
 		0x00, 0x00, 0x00, 0x0d, // 13 bytes
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	".."
+	"io"
+	"os"
+	"text/tabwriter"
+)
+
+func percent(covered, total int) string {
+	if total == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%.1f%% (%d/%d)", 100*float64(covered)/float64(total), covered, total)
+}
+
+// summary prints the percentage of instructions and branches covered in
+// each function, and in total
+func summary(w io.Writer, c *govm.Coverage) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "position\tfunction\tinstructions\tbranches")
+	var instrs, branches [2]int
+	for _, f := range c.Funcs {
+		pos := "-"
+		if len(f.Instrs) > 0 && f.Instrs[0].Pos.Line > 0 {
+			pos = f.Instrs[0].Pos.String()
+		}
+		ic, it := f.Instructions()
+		bc, bt := f.Branches()
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", pos, f.Name, percent(ic, it), percent(bc, bt))
+		instrs[0] += ic
+		instrs[1] += it
+		branches[0] += bc
+		branches[1] += bt
+	}
+	fmt.Fprintf(tw, "total\t\t%s\t%s\n", percent(instrs[0], instrs[1]), percent(branches[0], branches[1]))
+	return tw.Flush()
+}
+
+// A line of source with the instructions generated from it
+type line struct {
+	count    int // The largest number of times an instruction on the line was executed
+	instrs   int
+	branches []*govm.InstrCoverage
+}
+
+// annotate formats the count and branches of a line. The count of a line
+// that no instructions were generated from is "-".
+func (l *line) annotate() (count, branches string) {
+	if l == nil || l.instrs == 0 {
+		return "-", ""
+	}
+	count = fmt.Sprint(l.count)
+	if l.count == 0 {
+		count = "#####"
+	}
+	for _, b := range l.branches {
+		branches += fmt.Sprintf("  [taken %d, not taken %d]", b.Taken, b.NotTaken)
+	}
+	return count, branches
+}
+
+// list prints each source file with the number of times each line was
+// executed. Lines that were never executed are marked with #####, and
+// conditional jumps are followed by the number of times they were taken and
+// not taken. Code without debug info is listed by offset instead.
+func list(w io.Writer, c *govm.Coverage) error {
+	var files []string
+	lines := make(map[string]map[int]*line)
+	for _, f := range c.Funcs {
+		var noPos []*govm.InstrCoverage
+		for _, ic := range f.Instrs {
+			if ic.Pos.Line == 0 {
+				noPos = append(noPos, ic)
+				continue
+			}
+			if lines[ic.Pos.File] == nil {
+				lines[ic.Pos.File] = make(map[int]*line)
+				files = append(files, ic.Pos.File)
+			}
+			l := lines[ic.Pos.File][ic.Pos.Line]
+			if l == nil {
+				l = &line{}
+				lines[ic.Pos.File][ic.Pos.Line] = l
+			}
+			l.instrs++
+			if ic.Count > l.count {
+				l.count = ic.Count
+			}
+			if ic.Branch {
+				l.branches = append(l.branches, ic)
+			}
+		}
+
+		if len(noPos) > 0 {
+			fmt.Fprintf(w, "%s:\n", f.Name)
+			for _, ic := range noPos {
+				l := &line{ic.Count, 1, nil}
+				if ic.Branch {
+					l.branches = []*govm.InstrCoverage{ic}
+				}
+				count, branches := l.annotate()
+				fmt.Fprintf(w, "%9s: %#x%s\n", count, ic.Offset, branches)
+			}
+			fmt.Fprintln(w)
+		}
+	}
+
+	for _, name := range files {
+		f, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "%s:\n", name)
+		s := bufio.NewScanner(f)
+		for n := 1; s.Scan(); n++ {
+			count, branches := lines[name][n].annotate()
+			fmt.Fprintf(w, "%9s:%5d: %s%s\n", count, n, s.Text(), branches)
+		}
+		f.Close()
+		if err := s.Err(); err != nil {
+			return err
+		}
+		fmt.Fprintln(w)
+	}
+	return nil
+}
+
+func Main() int {
+	annotate := flag.Bool("list", false, "Print an annotated listing of the source, instead of a summary")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [-list] profile\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		return 2
+	}
+
+	f, err := os.Open(flag.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer f.Close()
+	c, err := govm.ReadCoverage(f)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	if *annotate {
+		err = list(os.Stdout, c)
+	} else {
+		err = summary(os.Stdout, c)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+func main() {
+	os.Exit(Main())
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"../asm"
+)
+
+func Main() int {
+	var output string
+	flag.StringVar(&output, "o", "", "Output filename. Defaults to standard output")
+	flag.Parse()
+
+	var in io.Reader
+	var out io.Writer
+	var err error
+
+	if flag.NArg() > 0 {
+		in, err = os.Open(flag.Arg(0))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	} else {
+		in = os.Stdin
+	}
+
+	data, err := io.ReadAll(in)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	if output == "" {
+		out = os.Stdout
+	} else {
+		f, err := os.Create(output)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		defer f.Close()
+		out = f
+	}
+	if err := asm.Disassemble(out, data); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	return 0
+}
+
+func main() {
+	os.Exit(Main())
+}
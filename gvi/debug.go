@@ -0,0 +1,250 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	".."
+	"../types"
+	"io"
+	"strconv"
+	"strings"
+)
+
+var errQuit = errors.New("Quit")
+
+type mode int
+
+const (
+	running  mode = iota
+	stepping      // Stop before the next instruction
+	stepOver      // Stop before the next instruction not in a function called from the current one
+)
+
+// debugger is a VM hook which pauses execution at breakpoints or after a
+// step, and reads commands until told to continue
+type debugger struct {
+	in      *bufio.Scanner
+	out     io.Writer
+	breaks  []*breakpoint // nil once deleted, so numbers don't change
+	mode    mode
+	depth   int       // Call depth when next was used
+	last    types.Pos // Position of the previous instruction
+	command string    // Last command, repeated by an empty line
+}
+
+func newDebugger(in io.Reader, out io.Writer) *debugger {
+	return &debugger{bufio.NewScanner(in), out, nil, stepping, 0, types.Pos{}, ""}
+}
+
+// A breakpoint is either at an offset in the code, an offset from the start
+// of a named function or a line of source
+type breakpoint struct {
+	spec   string
+	fn     types.Symbol
+	offset int // Relative to the start of fn, if set
+	file   string
+	line   int
+}
+
+func parseBreakpoint(spec string) (*breakpoint, error) {
+	b := &breakpoint{spec, "", -1, "", 0}
+	if strings.HasPrefix(spec, "0x") {
+		off, err := strconv.ParseInt(spec, 0, 0)
+		b.offset = int(off)
+		return b, err
+	}
+	if line, err := strconv.Atoi(spec); err == nil {
+		b.line = line
+		return b, nil
+	}
+	if i := strings.LastIndexByte(spec, ':'); i > 0 && !strings.ContainsRune(spec[:i], ':') {
+		if line, err := strconv.Atoi(spec[i+1:]); err == nil {
+			b.file, b.line = spec[:i], line
+			return b, nil
+		}
+	}
+
+	b.fn, b.offset = types.Symbol(strings.TrimPrefix(spec, "@")), 0
+	if i := strings.LastIndexByte(string(b.fn), '+'); i > 0 {
+		off, err := strconv.ParseInt(string(b.fn[i+1:]), 0, 0)
+		if err != nil {
+			return nil, err
+		}
+		b.fn, b.offset = b.fn[:i], int(off)
+	}
+	return b, nil
+}
+
+// match returns whether execution should stop at loc. newLine is set if loc
+// is the first instruction of a line.
+func (b *breakpoint) match(v *govm.VM, loc govm.Frame, newLine bool) bool {
+	switch {
+	case b.line > 0:
+		return newLine && loc.Pos.Line == b.line && (b.file == "" || b.file == loc.Pos.File)
+	case b.fn != "":
+		if loc.Func == nil || len(loc.Func.Code) == 0 {
+			return false
+		}
+		val, err := v.Scope().Get(b.fn)
+		f, ok := val.(types.Function)
+		if err != nil || !ok || len(f.Code) == 0 || &f.Code[0] != &loc.Func.Code[0] {
+			return false
+		}
+		return loc.Offset == f.Code[0].Offset+b.offset
+	default:
+		return loc.Offset == b.offset
+	}
+}
+
+func (d *debugger) hook(v *govm.VM) error {
+	loc := v.Location()
+	newLine := loc.Pos != d.last
+	d.last = loc.Pos
+
+	stop := d.mode == stepping || d.mode == stepOver && v.Depth() <= d.depth
+	for i, b := range d.breaks {
+		if b != nil && b.match(v, loc, newLine) {
+			fmt.Fprintf(d.out, "Breakpoint %d, ", i+1)
+			stop = true
+			break
+		}
+	}
+	if !stop {
+		return nil
+	}
+	d.where(v)
+	return d.prompt(v)
+}
+
+const help = `Commands:
+  s, step            Execute one instruction
+  n, next            Execute one instruction, including any function it calls
+  c, continue        Continue until a breakpoint is reached
+  b, break LOCATION  Set a breakpoint at LOCATION, which is one of:
+                       0xOFFSET      an offset in the code
+                       FUNC[+0xOFF]  an offset from the start of the function
+                                     in variable FUNC
+                       [FILE:]LINE   the first instruction of a source line
+  d, delete N        Delete breakpoint N
+  i, info            List breakpoints
+  p, stack           Print the stack
+  v, vars            Print the variables in scope, except builtins
+  w, where           Print the next instruction
+  q, quit            Stop the program
+An empty line repeats the last command.
+`
+
+// prompt reads and runs commands until one continues execution
+func (d *debugger) prompt(v *govm.VM) error {
+	for {
+		fmt.Fprint(d.out, "(gvi) ")
+		if !d.in.Scan() {
+			// Out of commands, so let the program finish
+			fmt.Fprintln(d.out)
+			d.mode, d.breaks = running, nil
+			return nil
+		}
+		line := strings.TrimSpace(d.in.Text())
+		if line == "" {
+			line = d.command
+		}
+		d.command = line
+		args := strings.Fields(line)
+		if len(args) == 0 {
+			continue
+		}
+
+		switch args[0] {
+		case "s", "step":
+			d.mode = stepping
+			return nil
+		case "n", "next":
+			d.mode, d.depth = stepOver, v.Depth()
+			return nil
+		case "c", "continue":
+			d.mode = running
+			return nil
+		case "q", "quit":
+			return errQuit
+
+		case "b", "break":
+			if len(args) != 2 {
+				fmt.Fprintln(d.out, "Usage: break LOCATION")
+				continue
+			}
+			b, err := parseBreakpoint(args[1])
+			if err != nil {
+				fmt.Fprintln(d.out, "Invalid location:", args[1])
+				continue
+			}
+			d.breaks = append(d.breaks, b)
+			fmt.Fprintf(d.out, "Breakpoint %d at %s\n", len(d.breaks), b.spec)
+		case "d", "delete":
+			n := 0
+			if len(args) == 2 {
+				n, _ = strconv.Atoi(args[1])
+			}
+			if n < 1 || n > len(d.breaks) || d.breaks[n-1] == nil {
+				fmt.Fprintln(d.out, "No such breakpoint")
+				continue
+			}
+			d.breaks[n-1] = nil
+		case "i", "info":
+			for i, b := range d.breaks {
+				if b != nil {
+					fmt.Fprintf(d.out, "%d\t%s\n", i+1, b.spec)
+				}
+			}
+
+		case "p", "stack":
+			printStack(d.out, v)
+		case "v", "vars":
+			printVars(d.out, v)
+		case "w", "where":
+			d.where(v)
+		case "h", "help":
+			fmt.Fprint(d.out, help)
+		default:
+			fmt.Fprintf(d.out, "Unknown command %q. Try help\n", args[0])
+		}
+	}
+}
+
+// where prints the location and the next instruction
+func (d *debugger) where(v *govm.VM) {
+	in, ok := v.Instruction()
+	if !ok {
+		return
+	}
+	fmt.Fprintf(d.out, "%s: %s\n", v.Location(), formatInstruction(in))
+}
+
+// printStack prints the values on the stack, top first
+func printStack(w io.Writer, v *govm.VM) {
+	stack := v.Stack()
+	if len(stack) == 0 {
+		fmt.Fprintln(w, "Stack is empty")
+	}
+	for i := len(stack) - 1; i >= 0; i-- {
+		fmt.Fprintf(w, "%d\t%s\n", len(stack)-1-i, types.Format(stack[i]))
+	}
+}
+
+// printVars prints the variables in scope, innermost first. Shadowed
+// variables and builtins are omitted.
+func printVars(w io.Writer, v *govm.VM) {
+	seen := make(map[types.Symbol]bool)
+	for s := v.Scope(); s != nil; s = s.Parent {
+		for _, name := range s.Names() {
+			if seen[name] {
+				continue
+			}
+			seen[name] = true
+			val, _ := s.Get(name)
+			if _, ok := val.(types.Builtin); !ok {
+				fmt.Fprintf(w, "%s = %s\n", name, types.Format(val))
+			}
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	".."
+	"../asm"
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// A function called from the top level. The func header is 19 bytes, so inc
+// is at 0x13, and call is at 0x23.
+const debugSrc = `
+func :int->int
+	inc
+endfunc
+set @f:int->int
+push 1
+get @f:int->int
+call
+set @x
+`
+
+// assemble converts GVA to GVB
+func assemble(t *testing.T, src string) []byte {
+	g, err := asm.Assemble(strings.NewReader(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, err := g.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return code
+}
+
+// debug runs src in the debugger with commands as its input, and returns
+// the error from Load and the output
+func debug(t *testing.T, src, commands string) (error, string) {
+	out := bytes.Buffer{}
+	vm := govm.New()
+	vm.Hook = newDebugger(strings.NewReader(commands), &out).hook
+	err := vm.Load(assemble(t, src))
+	return err, out.String()
+}
+
+func TestDebugger(t *testing.T) {
+	err, out := debug(t, debugSrc, strings.Join([]string{
+		"b f:int->int",
+		"i",
+		"c",
+		"p",
+		"d 1",
+		"d 1",
+		"", // Repeats d 1
+		"s",
+		"v",
+		"bogus",
+		"c",
+	}, "\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `<toplevel>+0x0: func :int->int
+(gvi) Breakpoint 1 at f:int->int
+(gvi) 1	f:int->int
+(gvi) Breakpoint 1, func(:int->int)+0x13: inc
+(gvi) 0	1
+(gvi) (gvi) No such breakpoint
+(gvi) No such breakpoint
+(gvi) <toplevel>+0x24: set @x
+(gvi) f:int->int = func(:int->int)
+(gvi) Unknown command "bogus". Try help
+(gvi) `
+	if out != expected {
+		t.Errorf("Expected output:\n%s\ngot:\n%s", expected, out)
+	}
+}
+
+func TestDebuggerNext(t *testing.T) {
+	// next steps over the call
+	err, out := debug(t, debugSrc, "b 0x23\nc\nn\np\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{
+		"Breakpoint 1, <toplevel>+0x23: call\n",
+		"(gvi) <toplevel>+0x24: set @x\n",
+		"(gvi) 0\t2\n",
+	} {
+		if !strings.Contains(out, s) {
+			t.Errorf("Expected %q in output:\n%s", s, out)
+		}
+	}
+	if strings.Contains(out, "inc") {
+		t.Error("Expected not to stop in f:\n", out)
+	}
+
+	// Running out of commands lets the program finish, and quit stops it
+	if err, _ := debug(t, debugSrc, "s\n"); err != nil {
+		t.Error("Expected the program to finish, got", err)
+	}
+	if err, _ := debug(t, debugSrc, "s\nq\n"); !errors.Is(err, errQuit) {
+		t.Error("Expected quit error, got", err)
+	}
+}
+
+func TestParseBreakpoint(t *testing.T) {
+	for _, test := range []struct {
+		spec     string
+		expected breakpoint
+	}{
+		{"0x1f", breakpoint{"0x1f", "", 0x1f, "", 0}},
+		{"12", breakpoint{"12", "", -1, "", 12}},
+		{"main.gva:3", breakpoint{"main.gva:3", "", -1, "main.gva", 3}},
+		{"Main:", breakpoint{"Main:", "Main:", 0, "", 0}},
+		{"@f:int->int+0x4", breakpoint{"@f:int->int+0x4", "f:int->int", 4, "", 0}},
+	} {
+		b, err := parseBreakpoint(test.spec)
+		if err != nil {
+			t.Errorf("%s: %v", test.spec, err)
+		} else if *b != test.expected {
+			t.Errorf("%s: expected %+v, got %+v", test.spec, test.expected, *b)
+		}
+	}
+	for _, spec := range []string{"0xzz", "f:int+zz"} {
+		if _, err := parseBreakpoint(spec); err == nil {
+			t.Errorf("%s: expected an error", spec)
+		}
+	}
+}
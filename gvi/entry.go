@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	".."
+	"../types"
+	"strings"
+)
+
+// The signatures an entry point may have, in the order they are looked for.
+// Its arguments are the command-line arguments after the file, and the int
+// it returns is the exit status, which must be from 0 to 255.
+var entrySigs = []string{"[]string->int", "[]string", "->int", ""}
+
+type EntryError struct {
+	Name types.Symbol
+	Type types.Type
+}
+
+func (e EntryError) Error() string {
+	return fmt.Sprintf("Entry point %s has type %s, expected func(:[]string->int), func(:[]string), func(:->int) or func()", e.Name, e.Type)
+}
+
+// StatusError is returned when the entry point returns an exit status that
+// isn't between 0 and 255, which would be truncated by the OS
+type StatusError struct{ Status int }
+
+func (e StatusError) Error() string {
+	return fmt.Sprintf("Invalid exit status %d, expected 0 to 255", e.Status)
+}
+
+// findEntry returns the variable holding the entry point. If name has no
+// signature, each of entrySigs is tried.
+func findEntry(vm *govm.VM, name string) (types.Symbol, error) {
+	if strings.ContainsRune(name, ':') {
+		return types.Symbol(name), nil
+	}
+	for _, sig := range entrySigs {
+		sym := types.Symbol(name + ":" + sig)
+		if _, err := vm.Scope().Get(sym); err == nil {
+			return sym, nil
+		}
+	}
+	return "", types.NameError{types.Symbol(name + ":")}
+}
+
+func validEntry(typ types.Type) bool {
+	if typ.Kind != types.FuncT {
+		return false
+	}
+	sig := strings.TrimPrefix(typ.Sig.String(), ":")
+	for _, s := range entrySigs {
+		if sig == s {
+			return true
+		}
+	}
+	return false
+}
+
+// callEntry calls the entry point with args, and returns the exit status
+func callEntry(vm *govm.VM, name string, args []string) (int, error) {
+	sym, err := findEntry(vm, name)
+	if err != nil {
+		return 1, err
+	}
+	f, err := vm.Scope().Get(sym)
+	if err != nil {
+		return 1, err
+	}
+	typ := types.TypeOf(f)
+	if !validEntry(typ) {
+		return 1, EntryError{sym, typ}
+	}
+
+	if len(typ.Sig.Args) > 0 {
+		arr := types.Array{types.TypeString, make([]types.Value, len(args))}
+		for i, arg := range args {
+			arr.V[i] = arg
+		}
+		vm.Push(arr)
+	}
+	vm.Push(f)
+	if err := vm.Call(); err != nil {
+		return 1, err
+	}
+	if len(typ.Sig.Ret) > 0 {
+		status, err := vm.Pop()
+		if err != nil {
+			return 1, err
+		}
+		code := status.(int)
+		if code < 0 || code > 255 {
+			return 1, StatusError{code}
+		}
+		return code, nil
+	}
+	return 0, nil
+}
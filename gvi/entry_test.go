@@ -0,0 +1,127 @@
+package main
+
+import (
+	".."
+	"../codegen"
+	"../types"
+	"testing"
+)
+
+const entrySrc = `
+func :[]string->int
+	len
+endfunc
+set @Args:[]string->int
+
+func :[]string
+	len
+	get @Record:int
+	call
+endfunc
+set @Count:[]string
+
+func :->int
+	push 3
+endfunc
+set @Status:->int
+
+func :->int
+	push 255
+endfunc
+set @Max:->int
+
+func :->int
+	push 256
+endfunc
+set @Big:->int
+
+func :->int
+	push -1
+endfunc
+set @Negative:->int
+
+func :
+	push 1
+	get @Record:int
+	call
+endfunc
+set @Run:
+
+func :int->int
+endfunc
+set @Bad:int->int
+
+func :->int
+	push "failed"
+	get @Error:string->error
+	call
+	throw
+	push 0
+endfunc
+set @Fail:->int
+`
+
+func TestCallEntry(t *testing.T) {
+	// Record sets n, as functions can't set variables outside themselves
+	n := 0
+	vm := govm.New()
+	vm.Builtin(codegen.Sig(":int"), func(_ types.Caller, args ...types.Value) ([]types.Value, error) {
+		n = args[0].(int)
+		return nil, nil
+	})
+	vm.Set("Record:int")
+	if err := vm.Load(assemble(t, entrySrc)); err != nil {
+		t.Fatal(err)
+	}
+	args := []string{"a", "b"}
+	for _, test := range []struct {
+		name   string
+		status int
+		n      int // The value recorded
+	}{
+		{"Args", 2, 0},
+		{"Args:[]string->int", 2, 0},
+		{"Count", 0, 2},
+		{"Status", 3, 2},
+		{"Run", 0, 1},
+		{"Max", 255, 1},
+	} {
+		status, err := callEntry(&vm, test.name, args)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if status != test.status || n != test.n {
+			t.Errorf("%s: expected status %d and n %d, got %d and %v", test.name, test.status, test.n, status, n)
+		}
+	}
+
+	// Functions with other types can't be entry points
+	status, err := callEntry(&vm, "Bad:int->int", args)
+	if _, ok := err.(EntryError); !ok || status != 1 {
+		t.Error("Expected EntryError and status 1, got", err, status)
+	}
+	if _, err := findEntry(&vm, "Bad"); !isNameError(err) {
+		t.Error("Expected NameError, got", err)
+	}
+	status, err = callEntry(&vm, "Fail", args)
+	if err == nil || status != 1 {
+		t.Error("Expected an error and status 1, got", err, status)
+	}
+	// Statuses the OS can't return are errors, rather than being truncated
+	for _, name := range []string{"Big", "Negative"} {
+		status, err = callEntry(&vm, name, args)
+		if _, ok := err.(StatusError); !ok || status != 1 {
+			t.Errorf("%s: expected StatusError and status 1, got %v and %d", name, err, status)
+		}
+	}
+	status, err = callEntry(&vm, "Missing", args)
+	if !isNameError(err) || status != 1 {
+		t.Error("Expected NameError and status 1, got", err, status)
+	}
+}
+
+func isNameError(err error) bool {
+	_, ok := err.(types.NameError)
+	return ok
+}
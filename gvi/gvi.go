@@ -1,6 +1,7 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
 	".."
@@ -9,33 +10,124 @@ import (
 )
 
 func Main() int {
+	verify := flag.Bool("verify", false, "Refuse to run code that fails verification")
+	debug := flag.Bool("debug", false, "Run in the interactive debugger")
+	trace := flag.Bool("trace", false, "Print each instruction to standard error as it is executed")
+	cpuprofile := flag.String("cpuprofile", "", "Write a profile of the program to `file` in pprof format")
+	folded := flag.Bool("folded", false, "Write the profile as folded stacks, for flame graphs, instead of in pprof format")
+	coverprofile := flag.String("coverprofile", "", "Write a coverage profile to `file`, which can be read by gvcover")
+	repl := flag.Bool("repl", false, "Read and run GVA interactively, after loading the file if one is given")
+	entry := flag.String("entry", "Main", "Call `name` after loading the file. Its signature may be omitted")
 	flag.Parse()
 
+	if *repl && *debug {
+		fmt.Fprintln(os.Stderr, "-debug can't be used with -repl")
+		return 2
+	}
+
 	var input io.ReadSeeker
-	if len(flag.Args()) > 0 {
+	if flag.Arg(0) == "-" {
+		input = os.Stdin
+	} else if len(flag.Args()) > 0 {
 		var err error
 		input, err = os.Open(flag.Arg(0))
 		if err != nil {
 			fmt.Fprintln(os.Stdout, err)
 			return 1
 		}
-	} else {
+	} else if !*repl {
 		input = os.Stdin
 	}
 
-	vm := govm.New()
+	var profile *govm.Profile
+	if *cpuprofile != "" {
+		profile = govm.NewProfile()
+		defer writeProfile(profile, *cpuprofile, *folded)
+	}
+	var coverage *govm.Coverage
+	if *coverprofile != "" {
+		coverage = govm.NewCoverage()
+		defer writeCoverage(coverage, *coverprofile)
+	}
+	newVM := func() *govm.VM {
+		vm := govm.New()
+		vm.Verify = *verify
+		if *debug {
+			vm.Hook = newDebugger(os.Stdin, os.Stdout).hook
+		}
+		if *trace {
+			vm.Trace = tracer(os.Stderr)
+		}
+		vm.Profile = profile
+		vm.Coverage = coverage
+		return &vm
+	}
+	vm := newVM()
 
-	vm.LoadFrom(input)
-	if err := vm.Get("Main:"); err != nil {
-		fmt.Fprintln(os.Stderr, err)
+	if *repl {
+		if input != nil {
+			if err := vm.LoadFrom(input); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return 1
+			}
+		}
+		newREPL(os.Stdin, os.Stdout, newVM, vm).run()
+		return 0
+	}
+
+	if err := vm.LoadFrom(input); err != nil {
+		if !errors.Is(err, errQuit) {
+			fmt.Fprintln(os.Stderr, err)
+		}
 		return 1
 	}
-	if err := vm.Call(); err != nil {
+	// The arguments after the file are passed to the entry point
+	var args []string
+	if len(flag.Args()) > 1 {
+		args = flag.Args()[1:]
+	}
+	status, err := callEntry(vm, *entry, args)
+	if err != nil && !errors.Is(err, errQuit) {
 		fmt.Fprintln(os.Stderr, err)
-		return 1
 	}
+	return status
+}
 
-	return 0
+func writeProfile(p *govm.Profile, name string, folded bool) {
+	f, err := os.Create(name)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return
+	}
+	if folded {
+		err = p.WriteFolded(f)
+	} else {
+		err = p.WritePprof(f)
+	}
+	if err == nil {
+		err = f.Close()
+	} else {
+		f.Close()
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+}
+
+func writeCoverage(c *govm.Coverage, name string) {
+	f, err := os.Create(name)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return
+	}
+	if _, err = c.WriteTo(f); err == nil {
+		err = f.Close()
+	} else {
+		f.Close()
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
 }
 
 func main() {
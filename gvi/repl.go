@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	".."
+	"../asm"
+	"../types"
+	"io"
+	"strings"
+)
+
+// repl reads GVA a line at a time and runs it in a VM that persists between
+// lines. Lines are collected until they form complete instructions, so
+// functions can span several lines.
+type repl struct {
+	in      *bufio.Scanner
+	out     io.Writer
+	newVM   func() *govm.VM
+	vm      *govm.VM
+	asm     *asm.Session
+	pending string // Lines read so far of incomplete instructions
+}
+
+func newREPL(in io.Reader, out io.Writer, newVM func() *govm.VM, vm *govm.VM) *repl {
+	return &repl{bufio.NewScanner(in), out, newVM, vm, asm.NewSession(), ""}
+}
+
+const replHelp = `Enter GVA instructions to run them. The stack is printed after each line.
+Commands:
+  :stack  Print the stack, top first
+  :scope  Print the variables in scope, except builtins
+  :reset  Start again with a new VM
+  :help   Print this help
+  :quit   Exit
+`
+
+// command runs line if it is a REPL command, returning whether it was one
+// and whether the REPL should exit
+func (r *repl) command(line string) (ok, quit bool) {
+	switch strings.TrimSpace(line) {
+	case ":stack":
+		printStack(r.out, r.vm)
+	case ":scope":
+		printVars(r.out, r.vm)
+	case ":reset":
+		r.vm = r.newVM()
+		r.asm = asm.NewSession()
+		r.pending = ""
+	case ":help":
+		fmt.Fprint(r.out, replHelp)
+	case ":quit":
+		return true, true
+	default:
+		return false, false
+	}
+	return true, false
+}
+
+func (r *repl) run() {
+	fmt.Fprintln(r.out, "Type :help for help")
+	for {
+		if r.pending == "" {
+			fmt.Fprint(r.out, "> ")
+		} else {
+			fmt.Fprint(r.out, "... ")
+		}
+		if !r.in.Scan() {
+			fmt.Fprintln(r.out)
+			return
+		}
+		line := r.in.Text()
+		ok, quit := r.command(line)
+		if quit {
+			return
+		}
+		if ok {
+			continue
+		}
+
+		r.pending += line + "\n"
+		code, err := r.assemble()
+		if err == io.ErrUnexpectedEOF {
+			continue
+		}
+		r.pending = ""
+		if err != nil {
+			fmt.Fprintln(r.out, err)
+			continue
+		}
+		if err := r.vm.Load(code); err != nil {
+			fmt.Fprintln(r.out, err)
+		}
+		r.printStack()
+	}
+}
+
+// assemble converts the pending lines to GVB
+func (r *repl) assemble() (code []byte, err error) {
+	// Invalid types cause panics while converting
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("%v", e)
+		}
+	}()
+	gen, err := r.asm.Assemble(strings.NewReader(r.pending))
+	if err != nil {
+		return nil, err
+	}
+	return gen.Generate()
+}
+
+// printStack prints the stack on one line, bottom first
+func (r *repl) printStack() {
+	stack := r.vm.Stack()
+	vals := make([]string, len(stack))
+	for i, val := range stack {
+		vals[i] = types.Format(val)
+	}
+	fmt.Fprintf(r.out, "[%s]\n", strings.Join(vals, ", "))
+}
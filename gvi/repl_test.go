@@ -0,0 +1,93 @@
+package main
+
+import (
+	".."
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func newTestVM() *govm.VM {
+	vm := govm.New()
+	vm.Verify = true
+	return &vm
+}
+
+// runREPL runs the REPL with lines as its input and returns the output
+func runREPL(lines ...string) string {
+	out := bytes.Buffer{}
+	newREPL(strings.NewReader(strings.Join(lines, "\n")), &out, newTestVM, newTestVM()).run()
+	return out.String()
+}
+
+func TestREPL(t *testing.T) {
+	out := runREPL(
+		"push 1",
+		"func :int->int", // Collected until endfunc
+		"inc",
+		"endfunc",
+		"set @f:int->int",
+		"get @f:int->int",
+		"call",
+		":stack",
+		":scope",
+		"bogus",
+		":reset", // Clears the stack and scope
+		":scope",
+		"push 3",
+		":quit",
+		"push 4",
+	)
+	expected := `Type :help for help
+> [1]
+> ... ... [1, func(:int->int)]
+> [1]
+> [1, func(:int->int)]
+> [2]
+> 0	2
+> f:int->int = func(:int->int)
+> Invalid opcode: bogus
+> > > [3]
+> `
+	if out != expected {
+		t.Errorf("Expected output:\n%s\ngot:\n%s", expected, out)
+	}
+}
+
+func TestREPLStructs(t *testing.T) {
+	// Structs defined on one line can be used on later ones, and the
+	// verifier sees the values already on the stack
+	out := runREPL(
+		"struct P :int",
+		"push 1",
+		"new P",
+		"set @p",
+		"get @p",
+		"push 2",
+		"fset 0",
+		"get @p",
+		"fget 0",
+	)
+	expected := `Type :help for help
+> []
+> [1]
+> [struct(0){1}]
+> []
+> [struct(0){1}]
+> [struct(0){1}, 2]
+> []
+> [struct(0){2}]
+> [2]
+> 
+`
+	if out != expected {
+		t.Errorf("Unexpected output:\n%s", out)
+	}
+}
+
+func TestREPLHelp(t *testing.T) {
+	out := runREPL(":help", "func :int")
+	if !strings.HasPrefix(out, "Type :help for help\n> "+replHelp+"> ... \n") {
+		t.Errorf("Unexpected output:\n%s", out)
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	".."
+	"../opcode"
+	"../types"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// formatInstruction formats an instruction and its operand like GVA. Jump
+// targets are omitted, as they are indices of instructions.
+func formatInstruction(in types.Instruction) string {
+	s := opcode.Name(in.Op)
+	switch in.Op {
+	case opcode.Push:
+		s += " " + types.Format(in.Val)
+	case opcode.Set, opcode.Get:
+		s += " @" + string(in.Val.(types.Symbol))
+	case opcode.LSet, opcode.LGet, opcode.New, opcode.FGet, opcode.FSet:
+		s += " " + strconv.Itoa(in.Arg)
+	case opcode.Func:
+		s += " " + in.Val.(types.Function).Sig.String()
+	case opcode.Make:
+		s += " " + in.Val.(types.Type).String()
+	}
+	return s
+}
+
+// tracer returns a trace function which prints each instruction to w,
+// indented by call depth, with the size and top of the stack
+func tracer(w io.Writer) func(govm.TraceEvent) {
+	return func(e govm.TraceEvent) {
+		frame := govm.Frame{e.Func, e.Instruction.Offset, types.Pos{}}
+		top := ""
+		if e.Stack > 0 {
+			top = " " + types.Format(e.Top)
+		}
+		fmt.Fprintf(w, "%s%s: %s\t[%d]%s\n", strings.Repeat("  ", e.Depth), frame,
+			formatInstruction(e.Instruction), e.Stack, top)
+	}
+}
@@ -0,0 +1,61 @@
+package govm
+
+import "./types"
+
+// Location returns the frame of the instruction that will be executed next.
+// It is meant to be called from a Hook.
+func (v *VM) Location() Frame {
+	frame := Frame{v.fn, 0, types.Pos{}}
+	if v.pc < len(v.code) {
+		frame.Offset = v.code[v.pc].Offset
+		frame.Pos, _ = v.debugInfo().Lookup(frame.Offset)
+	}
+	return frame
+}
+
+// Instruction returns the instruction that will be executed next, if any
+func (v *VM) Instruction() (types.Instruction, bool) {
+	if v.pc >= len(v.code) {
+		return types.Instruction{}, false
+	}
+	return v.code[v.pc], true
+}
+
+// Depth returns the number of nested function calls being executed
+func (v *VM) Depth() int {
+	return v.depth
+}
+
+// Stack returns a copy of the values on the stack, with the top last
+func (v *VM) Stack() types.Stack {
+	return append(types.Stack(nil), v.stack...)
+}
+
+// Scope returns the current scope. Its parents hold the variables of
+// enclosing functions and, outermost, the global variables and stdlib.
+func (v *VM) Scope() *types.Scope {
+	return v.scope
+}
+
+// Structs returns the struct table, indexed by types.Type.I. It must not be
+// modified.
+func (v *VM) Structs() []types.StructDef {
+	return v.structs
+}
+
+// TraceEvent describes an instruction that is about to be executed
+type TraceEvent struct {
+	Instruction types.Instruction
+	Func        *types.Function // nil for code run directly by Load or LoadFrom
+	Depth       int             // Number of nested function calls
+	Stack       int             // Number of values on the stack
+	Top         types.Value     // Value on top of the stack, if any
+}
+
+func (v *VM) trace(in *types.Instruction) {
+	e := TraceEvent{*in, v.fn, v.depth, len(v.stack), nil}
+	if len(v.stack) > 0 {
+		e.Top = v.stack[len(v.stack)-1]
+	}
+	v.Trace(e)
+}
@@ -0,0 +1,99 @@
+package govm
+
+import (
+	"./codegen"
+	"./opcode"
+	"errors"
+	"testing"
+)
+
+func TestHook(t *testing.T) {
+	g := codegen.New()
+	end := new(int)
+	g.Func(codegen.Sig(":int->int"), end)
+	g.Inc()
+	g.Label(end)
+	g.Set("f:int->int")
+	g.Push(1)
+	g.Get("f:int->int")
+	g.Call()
+	code, err := g.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var ops []byte
+	var depths []int
+	v := New()
+	v.Hook = func(v *VM) error {
+		in, ok := v.Instruction()
+		if !ok {
+			t.Fatal("Hook called with no next instruction")
+		}
+		if loc := v.Location(); loc.Offset != in.Offset {
+			t.Error("Location and instruction differ:", loc, in)
+		}
+		ops = append(ops, in.Op)
+		depths = append(depths, v.Depth())
+		return nil
+	}
+	if err := v.Load(code); err != nil {
+		t.Fatal(err)
+	}
+	expected := []byte{opcode.Func, opcode.Set, opcode.Push, opcode.Get, opcode.Call, opcode.Inc}
+	if string(ops) != string(expected) {
+		t.Error("Expected hook to see", expected, "got", ops)
+	}
+	if depths[len(depths)-1] != 1 {
+		t.Error("Expected inc to be at depth 1, got", depths)
+	}
+	if s := v.Stack(); len(s) != 1 || s[0] != 2 {
+		t.Error("Expected stack [2], got", s)
+	}
+
+	// Errors from the hook stop execution
+	stop := errors.New("stop")
+	v.Hook = func(v *VM) error {
+		if in, _ := v.Instruction(); in.Op == opcode.Inc {
+			return stop
+		}
+		return nil
+	}
+	if err := v.Load(code); !errors.Is(err, stop) {
+		t.Error("Expected hook's error, got", err)
+	}
+}
+
+func TestTraceHook(t *testing.T) {
+	g := codegen.New()
+	end := new(int)
+	g.Func(codegen.Sig(":int->int"), end)
+	g.Inc()
+	g.Label(end)
+	g.Set("f:int->int")
+	g.Push(1)
+	g.Get("f:int->int")
+	g.Call()
+	code, err := g.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var events []TraceEvent
+	v := New()
+	v.Trace = func(e TraceEvent) {
+		events = append(events, e)
+	}
+	if err := v.Load(code); err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 6 {
+		t.Fatal("Expected 6 events, got", events)
+	}
+	if e := events[2]; e.Instruction.Op != opcode.Push || e.Instruction.Val != 1 || e.Stack != 0 || e.Func != nil {
+		t.Error("Expected push 1 on an empty stack at the top level, got", e)
+	}
+	if e := events[5]; e.Instruction.Op != opcode.Inc || e.Depth != 1 || e.Func == nil || e.Stack != 1 || e.Top != 1 {
+		t.Error("Expected inc of 1 at depth 1, got", e)
+	}
+}
@@ -0,0 +1,194 @@
+package govm
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"./types"
+)
+
+// ArgCountError is returned by Invoke and CallValue when the number of arguments doesn't
+// match the function's signature
+type ArgCountError struct{ Expected, Actual int }
+
+func (e ArgCountError) Error() string {
+	return fmt.Sprintf("Argument error: expected %d arguments, got %d", e.Expected, e.Actual)
+}
+
+// ArgError is returned by Invoke and CallValue when an argument can't be converted or
+// doesn't have the type in the function's signature
+type ArgError struct {
+	N   int // Index of the argument
+	Err error
+}
+
+func (e ArgError) Error() string {
+	return fmt.Sprintf("Argument %d: %v", e.N, e.Err)
+}
+
+func (e ArgError) Unwrap() error {
+	return e.Err
+}
+
+// ResultCountError is returned by Invoke1 when the function doesn't return
+// exactly one result
+type ResultCountError struct{ Actual int }
+
+func (e ResultCountError) Error() string {
+	return fmt.Sprintf("Result error: expected 1 result, got %d", e.Actual)
+}
+
+// AmbiguousNameError is returned by Invoke when a name without a signature
+// matches more than one variable
+type AmbiguousNameError struct {
+	Name    string
+	Symbols []types.Symbol
+}
+
+func (e AmbiguousNameError) Error() string {
+	return fmt.Sprintf("Name error: %s is ambiguous, could be any of %v", e.Name, e.Symbols)
+}
+
+// lookupFunc returns the variable named name. If name has no signature, it
+// must be the name of exactly one variable, ignoring signatures.
+func (v *VM) lookupFunc(name string) (types.Value, error) {
+	if strings.ContainsRune(name, ':') {
+		return v.scope.Get(types.Symbol(name))
+	}
+	var found []types.Symbol
+	seen := make(map[types.Symbol]bool)
+	for s := v.scope; s != nil; s = s.Parent {
+		for _, sym := range s.Names() {
+			if !seen[sym] && strings.HasPrefix(string(sym), name+":") {
+				found = append(found, sym)
+			}
+			seen[sym] = true
+		}
+	}
+	switch len(found) {
+	case 0:
+		return nil, types.NameError{types.Symbol(name + ":")}
+	case 1:
+		return v.scope.Get(found[0])
+	default:
+		return nil, AmbiguousNameError{name, found}
+	}
+}
+
+// value converts a Go value to a value. Values are used as they are, and
+// other Go values are converted as described by BuiltinOf.
+func value(arg interface{}) (types.Value, error) {
+	if types.TypeOf(arg).Kind != 0 {
+		return arg, nil
+	}
+	if arg == nil {
+		return nil, types.ValueError{arg}
+	}
+	t, err := goType(reflect.TypeOf(arg))
+	if err != nil {
+		return nil, err
+	}
+	return toValue(reflect.ValueOf(arg), t), nil
+}
+
+// Invoke calls the function in the variable called name with args, and
+// returns its results in the order they are declared. name may omit the
+// signature if only one variable has that name. Each argument is either a
+// value or a Go value that can be converted to one, as described by
+// BuiltinOf, and must have the type in the function's signature.
+//
+// The stack is left as it was before the call, even if the call fails.
+func (v *VM) Invoke(name string, args ...interface{}) ([]types.Value, error) {
+	f, err := v.lookupFunc(name)
+	if err != nil {
+		return nil, err
+	}
+	vals := make([]types.Value, len(args))
+	for i, arg := range args {
+		if vals[i], err = value(arg); err != nil {
+			return nil, ArgError{i, err}
+		}
+	}
+	return v.CallValue(f, vals...)
+}
+
+// CallValue calls the function value f with args, and returns its results in
+// the order they are declared. It can be used by builtins to call functions
+// passed to them, and by code outside the VM. The args must have the types
+// in f's signature.
+//
+// Errors other than those thrown by f are returned as a *RuntimeError, so
+// that a builtin returning one can't have it caught by a try region.
+//
+// The stack is left as it was before the call, even if the call fails.
+func (v *VM) CallValue(f types.Value, args ...types.Value) ([]types.Value, error) {
+	rets, err := v.callValue(f, args...)
+	if _, ok := err.(types.Error); err != nil && !ok && !errors.As(err, new(*RuntimeError)) {
+		err = &RuntimeError{err, nil}
+	}
+	return rets, err
+}
+
+func (v *VM) callValue(f types.Value, args ...types.Value) ([]types.Value, error) {
+	typ := types.TypeOf(f)
+	if typ.Kind != types.FuncT {
+		return nil, types.TypeError{types.TypeFunc, typ}
+	}
+	sig := typ.Sig
+	if len(args) != len(sig.Args) {
+		return nil, ArgCountError{len(sig.Args), len(args)}
+	}
+	for i, arg := range args {
+		if err := sig.Args[i].TypeCheck(arg); err != nil {
+			return nil, ArgError{i, err}
+		}
+	}
+
+	base := len(v.stack)
+	defer func() {
+		if len(v.stack) > base {
+			v.stack = v.stack[:base]
+		}
+	}()
+	v.stack = append(v.stack, args...)
+	v.Push(f)
+	if err := v.Call(); err != nil {
+		return nil, err
+	}
+	// Call checks the types of the results on top of the stack
+	rets := make([]types.Value, len(sig.Ret))
+	copy(rets, v.stack[len(v.stack)-len(rets):])
+	return rets, nil
+}
+
+// Invoke1 is like Invoke, for a function that returns a single result, which
+// is converted to T. T may be a Go type that the result can be converted to,
+// as described by BuiltinOf, or any type the result can be assigned to, such
+// as types.Value.
+func Invoke1[T any](v *VM, name string, args ...interface{}) (T, error) {
+	var res T
+	rets, err := v.Invoke(name, args...)
+	if err != nil {
+		return res, err
+	}
+	if len(rets) != 1 {
+		return res, ResultCountError{len(rets)}
+	}
+
+	rt := reflect.TypeOf(&res).Elem()
+	rv := reflect.ValueOf(rets[0])
+	if rv.Type().AssignableTo(rt) {
+		reflect.ValueOf(&res).Elem().Set(rv)
+		return res, nil
+	}
+	t, err := goType(rt)
+	if err != nil {
+		return res, err
+	}
+	if err := t.TypeCheck(rets[0]); err != nil {
+		return res, err
+	}
+	reflect.ValueOf(&res).Elem().Set(fromValue(rets[0], rt))
+	return res, nil
+}
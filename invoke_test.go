@@ -0,0 +1,117 @@
+package govm
+
+import (
+	"./asm"
+	"./types"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const invokeSrc = `
+func :int:int->int:string
+	add
+	dup
+	get @ToString:int->string
+	call
+endfunc
+set @Add:int:int->int:string
+
+func :[]int->int
+	len
+endfunc
+set @Len:[]int->int
+
+func :int->int
+	push 5
+	get @missing
+endfunc
+set @Fail:int->int
+
+func :->int
+	push 1
+endfunc
+dup
+dup
+set @One:->int
+set @Two:->int
+set @Two:->float
+`
+
+type count int
+
+func loadInvoke(t *testing.T) VM {
+	g, err := asm.Assemble(strings.NewReader(invokeSrc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, err := g.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := New()
+	if err := v.Load(code); err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func TestInvoke(t *testing.T) {
+	v := loadInvoke(t)
+	v.Push("below")
+
+	rets, err := v.Invoke("Add", 2, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(rets, []types.Value{5, "5"}) {
+		t.Error("Expected [5 5], got", rets)
+	}
+	if rets, err := v.Invoke("Len:[]int->int", []int{1, 2, 3}); err != nil || rets[0] != 3 {
+		t.Error("Expected 3, got", rets, err)
+	}
+
+	for _, test := range []struct {
+		name string
+		args []interface{}
+		err  interface{}
+	}{
+		{"Add", []interface{}{1}, new(ArgCountError)},
+		{"Add", []interface{}{1, "x"}, new(ArgError)},
+		{"Len", []interface{}{[]string{"x"}}, new(types.TypeError)},
+		{"Len", []interface{}{[]int32{1}}, new(RegisterError)},
+		{"Nope", nil, new(types.NameError)},
+		{"Two", nil, new(AmbiguousNameError)},
+		{"Fail", []interface{}{1}, new(types.NameError)},
+	} {
+		_, err := v.Invoke(test.name, test.args...)
+		if err == nil || !errors.As(err, test.err) {
+			t.Errorf("%s%v: expected %T, got %v", test.name, test.args, test.err, err)
+		}
+	}
+
+	// The stack is balanced whether or not the calls succeed
+	if s := v.Stack(); len(s) != 1 || s[0] != "below" {
+		t.Error("Expected stack [below], got", s)
+	}
+}
+
+func TestInvoke1(t *testing.T) {
+	v := loadInvoke(t)
+	if n, err := Invoke1[int](&v, "One:->int"); err != nil || n != 1 {
+		t.Error("Expected 1, got", n, err)
+	}
+	if n, err := Invoke1[count](&v, "Len", []int{1, 2}); err != nil || n != 2 {
+		t.Error("Expected 2, got", n, err)
+	}
+	if val, err := Invoke1[types.Value](&v, "One"); err != nil || val != 1 {
+		t.Error("Expected 1, got", val, err)
+	}
+	if _, err := Invoke1[string](&v, "One:->int"); !errors.As(err, new(types.TypeError)) {
+		t.Error("Expected type error, got", err)
+	}
+	if _, err := Invoke1[int](&v, "Add", 1, 2); !errors.As(err, new(ResultCountError)) {
+		t.Error("Expected result count error, got", err)
+	}
+}
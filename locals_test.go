@@ -0,0 +1,64 @@
+package govm
+
+import (
+	"./codegen"
+	"./types"
+	"errors"
+	"testing"
+)
+
+func TestLocals(t *testing.T) {
+	// Sums the integers from 1 to n
+	g := codegen.New()
+	end := new(int)
+	locals := g.Func(codegen.Sig(":int->int"), end)
+	locals.N = 2
+	g.LSet(0) // n
+	g.Push(0)
+	g.LSet(1) // sum
+	loop := g.Label(nil)
+	done := new(int)
+	g.LGet(0)
+	g.JZ(done)
+	g.LGet(1)
+	g.LGet(0)
+	g.Add()
+	g.LSet(1)
+	g.LGet(0)
+	g.Dec()
+	g.LSet(0)
+	g.J(loop)
+	g.Label(done)
+	g.LGet(1)
+	g.Label(end)
+	g.Set("sum:int->int")
+
+	end = new(int)
+	g.Func(codegen.Sig(":"), end).N = 1
+	g.LGet(0)
+	g.Label(end)
+	g.Set("unset:")
+
+	code, err := g.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := New()
+	if err := v.Load(code); err != nil {
+		t.Fatal("Load:", err)
+	}
+
+	v.Push(10)
+	v.Get("sum:int->int")
+	if err := v.Call(); err != nil {
+		t.Fatal("Call:", err)
+	}
+	if sum, _ := v.Pop(); sum != 55 {
+		t.Error("Expected sum of 55, got", sum)
+	}
+
+	v.Get("unset:")
+	if err := v.Call(); !errors.As(err, new(types.LocalError)) {
+		t.Error("Expected local error, got", err)
+	}
+}
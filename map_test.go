@@ -0,0 +1,66 @@
+package govm
+
+import (
+	"./codegen"
+	"./types"
+	"testing"
+)
+
+func TestMap(t *testing.T) {
+	g := codegen.New()
+	g.Make(codegen.Typ("map[string]int"))
+	for i, k := range []string{"b", "c", "a"} {
+		g.Dup()
+		g.Push(k)
+		g.Push(i)
+		g.Store()
+	}
+	g.Dup()
+	g.Push("c")
+	g.Delete()
+	g.Set("m")
+
+	code, err := g.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := New()
+	if err := v.Load(code); err != nil {
+		t.Fatal("Load:", err)
+	}
+
+	v.Get("m")
+	v.Push("b")
+	if err := v.Lookup(); err != nil {
+		t.Fatal(err)
+	}
+	if found, _ := v.Pop(); found != true {
+		t.Error("Expected to find key \"b\"")
+	}
+	if val, _ := v.Pop(); val != 0 {
+		t.Error("Expected value 0, got", val)
+	}
+
+	v.Get("m")
+	v.Push("c")
+	v.Lookup()
+	if found, _ := v.Pop(); found != false {
+		t.Error("Expected deleted key \"c\" to be missing")
+	}
+	v.Pop()
+
+	v.Get("m")
+	if err := v.SKeys(); err != nil {
+		t.Fatal(err)
+	}
+	keys, _ := v.Pop()
+	if k := keys.(types.Array).V; len(k) != 2 || k[0] != "a" || k[1] != "b" {
+		t.Error("Expected sorted keys [a b], got", k)
+	}
+
+	v.Get("m")
+	v.Push(1)
+	if _, ok := v.Lookup().(types.TypeError); !ok {
+		t.Error("Expected type error from lookup")
+	}
+}
@@ -0,0 +1,85 @@
+package opcode
+
+import "fmt"
+
+// Names maps each opcode to its mnemonic in govm IR
+var Names = map[byte]string{
+	J:   "j",
+	JT:  "jt",
+	JF:  "jf",
+	JZ:  "jz",
+	JNz: "jnz",
+
+	Push: "push",
+	Pop:  "pop",
+	Dup:  "dup",
+	Swp:  "swp",
+	Set:  "set",
+	Get:  "get",
+	LSet: "lset",
+	LGet: "lget",
+
+	Inc: "inc",
+	Dec: "dec",
+	Add: "add",
+	Sub: "sub",
+	Mul: "mul",
+	Div: "div",
+	Mod: "mod",
+
+	EQ: "eq",
+	NE: "ne",
+	LT: "lt",
+	GT: "gt",
+	LE: "le",
+	GE: "ge",
+
+	And: "and",
+	Or:  "or",
+	Xor: "xor",
+	Not: "not",
+
+	BAnd: "band",
+	BOr:  "bor",
+	BXor: "bxor",
+	BNot: "bnot",
+	BLS:  "bls",
+	BRS:  "brs",
+
+	BSet:  "bset",
+	BClr:  "bclr",
+	BTgl:  "btgl",
+	BMtch: "bmtch",
+
+	Call: "call",
+	Ret:  "ret",
+	Func: "func",
+
+	New:  "new",
+	FGet: "fget",
+	FSet: "fset",
+
+	Make:   "make",
+	Index:  "index",
+	Store:  "store",
+	Len:    "len",
+	Append: "append",
+	Slice:  "slice",
+
+	Lookup: "lookup",
+	Delete: "delete",
+	Keys:   "keys",
+	SKeys:  "skeys",
+
+	Throw:  "throw",
+	Try:    "try",
+	EndTry: "endtry",
+}
+
+// Name returns the mnemonic of an opcode, or its value in hex if it is invalid
+func Name(op byte) string {
+	if name, ok := Names[op]; ok {
+		return name
+	}
+	return fmt.Sprintf("%#02x", op)
+}
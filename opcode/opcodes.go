@@ -10,10 +10,10 @@
 	3 - Logic
 	4 - Bitwise
 	5 - Functions
-	6 -
-	7 -
-	8 -
-	9 -
+	6 - Structs
+	7 - Arrays
+	8 - Maps
+	9 - Exceptions
 	a -
 	b -
 	c -
@@ -37,6 +37,8 @@ const (
 	Swp  byte = 0x13
 	Set  byte = 0x14
 	Get  byte = 0x15
+	LSet byte = 0x16
+	LGet byte = 0x17
 
 	Inc byte = 0x20
 	Dec byte = 0x21
@@ -73,4 +75,26 @@ const (
 	Call byte = 0x50
 	Ret  byte = 0x51
 	Func byte = 0x52
+
+	// 0x60 was the struct instruction, before struct tables were moved to
+	// the struct section. It is reserved so that old code fails to load.
+	New  byte = 0x61
+	FGet byte = 0x62
+	FSet byte = 0x63
+
+	Make   byte = 0x70
+	Index  byte = 0x71
+	Store  byte = 0x72
+	Len    byte = 0x73
+	Append byte = 0x74
+	Slice  byte = 0x75
+
+	Lookup byte = 0x80
+	Delete byte = 0x81
+	Keys   byte = 0x82
+	SKeys  byte = 0x83
+
+	Throw  byte = 0x90
+	Try    byte = 0x91
+	EndTry byte = 0x92
 )
@@ -0,0 +1,54 @@
+package govm
+
+import (
+	"./codegen"
+	"./types"
+	"errors"
+	"testing"
+)
+
+func TestStackOverflow(t *testing.T) {
+	g := codegen.New()
+	recEnd := new(int)
+	g.Func(codegen.Sig(":"), recEnd)
+	g.Get("rec:")
+	g.Call()
+	g.Label(recEnd)
+	g.Set("rec:")
+
+	pushEnd := new(int)
+	g.Func(codegen.Sig(":"), pushEnd)
+	start := g.Label(nil)
+	g.Push(1)
+	g.J(start)
+	g.Label(pushEnd)
+	g.Set("push:")
+
+	code, err := g.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := New()
+	v.MaxDepth = 100
+	v.MaxStack = 100
+	if err := v.Load(code); err != nil {
+		t.Fatal("Load:", err)
+	}
+
+	var overflow types.StackOverflow
+	v.Get("rec:")
+	if err := v.Call(); !errors.As(err, &overflow) || !overflow.Calls {
+		t.Error("Expected call depth overflow, got", err)
+	}
+	if v.depth != 0 {
+		t.Error("Expected depth to be reset, got", v.depth)
+	}
+
+	v.Get("push:")
+	if err := v.Call(); !errors.As(err, &overflow) || overflow.Calls {
+		t.Error("Expected stack overflow, got", err)
+	}
+	if len(v.stack) != 0 {
+		t.Error("Expected empty stack, got", len(v.stack), "values")
+	}
+}
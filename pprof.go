@@ -0,0 +1,191 @@
+package govm
+
+import (
+	"compress/gzip"
+	"io"
+)
+
+// protoBuffer encodes the subset of protocol buffers needed to write pprof
+// profiles
+type protoBuffer []byte
+
+func (b *protoBuffer) varint(x uint64) {
+	for x >= 0x80 {
+		*b = append(*b, byte(x)|0x80)
+		x >>= 7
+	}
+	*b = append(*b, byte(x))
+}
+
+func (b *protoBuffer) tag(field, wire int) {
+	b.varint(uint64(field)<<3 | uint64(wire))
+}
+
+// uint writes a varint field, omitting it if it is zero
+func (b *protoBuffer) uint(field int, x uint64) {
+	if x != 0 {
+		b.tag(field, 0)
+		b.varint(x)
+	}
+}
+
+func (b *protoBuffer) bytes(field int, data []byte) {
+	b.tag(field, 2)
+	b.varint(uint64(len(data)))
+	*b = append(*b, data...)
+}
+
+// packed writes a packed repeated varint field
+func (b *protoBuffer) packed(field int, xs []uint64) {
+	var p protoBuffer
+	for _, x := range xs {
+		p.varint(x)
+	}
+	b.bytes(field, p)
+}
+
+// Field numbers of the messages in pprof's profile.proto
+const (
+	pprofSampleType = 1
+	pprofSample     = 2
+	pprofLocation   = 4
+	pprofFunction   = 5
+	pprofStrings    = 6
+	pprofTime       = 9
+	pprofDuration   = 10
+	pprofPeriodType = 11
+	pprofPeriod     = 12
+
+	pprofSampleLocation = 1
+	pprofSampleValue    = 2
+
+	pprofLocationID      = 1
+	pprofLocationAddress = 3
+	pprofLocationLine    = 4
+	pprofLineFunction    = 1
+	pprofLineLine        = 2
+
+	pprofFunctionID       = 1
+	pprofFunctionName     = 2
+	pprofFunctionFilename = 4
+	pprofFunctionStart    = 5
+)
+
+// pprofWriter builds the tables of a pprof profile
+type pprofWriter struct {
+	protoBuffer
+	strings   map[string]int
+	functions map[profKey]uint64
+	locations map[locationKey]uint64
+}
+
+type locationKey struct {
+	key    profKey
+	offset int
+}
+
+func (w *pprofWriter) string(s string) uint64 {
+	i, ok := w.strings[s]
+	if !ok {
+		i = len(w.strings)
+		w.strings[s] = i
+		// The string table may be written before its users, as fields can
+		// appear in any order
+		w.bytes(pprofStrings, []byte(s))
+	}
+	return uint64(i)
+}
+
+func (w *pprofWriter) function(n *profNode) uint64 {
+	if id, ok := w.functions[n.key]; ok {
+		return id
+	}
+	id := uint64(len(w.functions) + 1)
+	w.functions[n.key] = id
+
+	var f protoBuffer
+	f.uint(pprofFunctionID, id)
+	f.uint(pprofFunctionName, w.string(n.name))
+	if n.start >= 0 {
+		if pos, ok := n.debug.Lookup(n.start); ok {
+			f.uint(pprofFunctionFilename, w.string(pos.File))
+			f.uint(pprofFunctionStart, uint64(pos.Line))
+		}
+	}
+	w.bytes(pprofFunction, f)
+	return id
+}
+
+// location returns the ID of the location of offset in n. Offset -1 is the
+// function as a whole.
+func (w *pprofWriter) location(n *profNode, offset int) uint64 {
+	loc := locationKey{n.key, offset}
+	if id, ok := w.locations[loc]; ok {
+		return id
+	}
+	function := w.function(n)
+	id := uint64(len(w.locations) + 1)
+	w.locations[loc] = id
+
+	var line protoBuffer
+	line.uint(pprofLineFunction, function)
+	if offset >= 0 {
+		if pos, ok := n.debug.Lookup(offset); ok {
+			line.uint(pprofLineLine, uint64(pos.Line))
+		}
+	}
+	var l protoBuffer
+	l.uint(pprofLocationID, id)
+	if offset >= 0 {
+		l.uint(pprofLocationAddress, uint64(offset))
+	}
+	l.bytes(pprofLocationLine, line)
+	w.bytes(pprofLocation, l)
+	return id
+}
+
+func valueType(typ, unit uint64) []byte {
+	var b protoBuffer
+	b.uint(1, typ)
+	b.uint(2, unit)
+	return b
+}
+
+// WritePprof writes the profile as a gzipped protocol buffer, which can be
+// read by go tool pprof. Each sample has two values, the number of
+// instructions executed and the time spent in nanoseconds. Locations'
+// addresses are offsets in the code, and their lines are taken from the
+// debug info, if there is any.
+func (p *Profile) WritePprof(out io.Writer) error {
+	w := pprofWriter{nil, make(map[string]int), make(map[profKey]uint64), make(map[locationKey]uint64)}
+	w.string("")
+	w.bytes(pprofSampleType, valueType(w.string("instructions"), w.string("count")))
+	w.bytes(pprofSampleType, valueType(w.string("time"), w.string("nanoseconds")))
+
+	p.root.walk(nil, func(n *profNode, _ []string) {
+		for _, offset := range n.offsets {
+			c := n.counts[offset]
+			locs := []uint64{w.location(n, offset)}
+			for m := n; m.parent != nil; m = m.parent {
+				locs = append(locs, w.location(m.parent, m.site))
+			}
+			var s protoBuffer
+			s.packed(pprofSampleLocation, locs)
+			s.packed(pprofSampleValue, []uint64{uint64(c.instructions), uint64(c.nanoseconds)})
+			w.bytes(pprofSample, s)
+		}
+	})
+
+	if !p.begin.IsZero() {
+		w.uint(pprofTime, uint64(p.begin.UnixNano()))
+		w.uint(pprofDuration, uint64(p.end.Sub(p.begin)))
+	}
+	w.bytes(pprofPeriodType, valueType(w.string("instructions"), w.string("count")))
+	w.uint(pprofPeriod, 1)
+
+	z := gzip.NewWriter(out)
+	if _, err := z.Write(w.protoBuffer); err != nil {
+		return err
+	}
+	return z.Close()
+}
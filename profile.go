@@ -0,0 +1,223 @@
+package govm
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+	"./types"
+)
+
+// Profile records the number of instructions executed and the wall time
+// spent at each offset of each function, for each stack of calls that led to
+// it. Time spent in a builtin is recorded against the builtin, as a callee of
+// the function that called it. A Profile is attached to a VM by setting its
+// Profile field.
+type Profile struct {
+	root  *profNode
+	node  *profNode  // Node of the code being executed
+	last  *profCount // Count of the instruction being executed, if any
+	start time.Time  // When the instruction being executed started
+
+	begin, end time.Time // When the first and last instructions were executed
+}
+
+// A profNode is a function in a particular stack of calls
+type profNode struct {
+	parent   *profNode
+	key      profKey
+	name     string
+	debug    *types.DebugInfo
+	start    int        // Offset of the function's first instruction, or -1 if unknown
+	site     int        // Offset of the call in the parent, or -1 if unknown
+	call     *profCount // Count of the call in the parent, resumed on return
+	children map[callKey]*profNode
+	order    []*profNode        // children in the order they were first called
+	counts   map[int]*profCount // Counts of the instructions in this node by offset
+	offsets  []int              // keys of counts in the order they were first executed
+}
+
+// A profKey identifies a function. Copies of a function share their code,
+// so functions are identified by the address of their first instruction,
+// and builtins by their ID.
+type profKey struct {
+	code    *types.Instruction
+	builtin *byte
+}
+
+// A callKey identifies a call of a function from a particular offset
+type callKey struct {
+	profKey
+	site int
+}
+
+type profCount struct {
+	instructions, nanoseconds int64
+}
+
+// NewProfile creates an empty profile
+func NewProfile() *Profile {
+	root := &profNode{nil, profKey{}, "<toplevel>", nil, -1, -1, nil, nil, nil, nil, nil}
+	return &Profile{root: root, node: root}
+}
+
+// child returns the node for key called from offset site of n, creating it
+// if needed
+func (n *profNode) child(key profKey, site int, name func() string) *profNode {
+	if c, ok := n.children[callKey{key, site}]; ok {
+		return c
+	}
+	if n.children == nil {
+		n.children = make(map[callKey]*profNode)
+	}
+	c := &profNode{n, key, name(), nil, -1, site, nil, nil, nil, nil, nil}
+	n.children[callKey{key, site}] = c
+	n.order = append(n.order, c)
+	return c
+}
+
+func (n *profNode) count(offset int) *profCount {
+	if c, ok := n.counts[offset]; ok {
+		return c
+	}
+	if n.counts == nil {
+		n.counts = make(map[int]*profCount)
+	}
+	c := &profCount{}
+	n.counts[offset] = c
+	n.offsets = append(n.offsets, offset)
+	return c
+}
+
+// flush adds the time since the last instruction started to its count
+func (p *Profile) flush(now time.Time) {
+	if p.last != nil {
+		p.last.nanoseconds += int64(now.Sub(p.start))
+	}
+	p.start = now
+	p.end = now
+}
+
+// instr is called before each instruction is executed
+func (p *Profile) instr(v *VM, offset int) {
+	now := time.Now()
+	if p.begin.IsZero() {
+		p.begin = now
+	}
+	p.flush(now)
+	if p.node.debug == nil {
+		p.node.debug = v.debugInfo()
+	}
+	p.last = p.node.count(offset)
+	p.last.instructions++
+}
+
+// enter is called when f is called, and must be followed by a call to exit
+// when it returns
+func (p *Profile) enter(v *VM, f types.Value) {
+	p.flush(time.Now())
+	var key profKey
+	start := -1
+	switch f := f.(type) {
+	case types.Function:
+		if len(f.Code) > 0 {
+			key.code = &f.Code[0]
+			start = f.Code[0].Offset
+		}
+	case types.Builtin:
+		key.builtin = f.ID()
+	}
+	site := -1
+	if p.last != nil && v.pc > 0 {
+		site = v.code[v.pc-1].Offset
+	}
+	n := p.node.child(key, site, func() string { return funcName(v.scope, f) })
+	n.start = start
+	n.call = p.last
+	p.node = n
+	// Builtins have no instructions, so their time is recorded at offset -1
+	p.last = nil
+	if key.builtin != nil {
+		p.last = n.count(-1)
+	}
+}
+
+// exit is called when the function passed to the last call to enter returns
+func (p *Profile) exit() {
+	p.flush(time.Now())
+	p.last = p.node.call
+	if p.node.parent != nil {
+		p.node = p.node.parent
+	}
+}
+
+// stop is called when the VM stops running, so that the time until it is
+// next run isn't recorded
+func (p *Profile) stop() {
+	p.flush(time.Now())
+	p.last = nil
+	p.node = p.root
+}
+
+// funcName returns the name of a variable in scope or its parents that holds
+// f, or a description of f if there is none
+func funcName(scope *types.Scope, f types.Value) string {
+	for s := scope; s != nil; s = s.Parent {
+		for _, name := range s.Names() {
+			val, _ := s.Get(name)
+			if sameFunc(val, f) {
+				return string(name)
+			}
+		}
+	}
+	switch f := f.(type) {
+	case types.Function:
+		if len(f.Code) > 0 {
+			return fmt.Sprintf("func(%s)@%#x", f.Sig, f.Code[0].Offset)
+		}
+		return fmt.Sprintf("func(%s)", f.Sig)
+	case types.Builtin:
+		return fmt.Sprintf("builtin(%s)", f.Sig)
+	}
+	return "?"
+}
+
+func sameFunc(a, b types.Value) bool {
+	switch a := a.(type) {
+	case types.Function:
+		b, ok := b.(types.Function)
+		return ok && len(a.Code) > 0 && len(b.Code) > 0 && &a.Code[0] == &b.Code[0]
+	case types.Builtin:
+		b, ok := b.(types.Builtin)
+		return ok && a.ID() == b.ID()
+	}
+	return false
+}
+
+// walk calls f with each node and the names of the nodes that lead to it,
+// outermost first
+func (n *profNode) walk(stack []string, f func(n *profNode, stack []string)) {
+	stack = append(stack, n.name)
+	f(n, stack)
+	for _, c := range n.order {
+		c.walk(stack, f)
+	}
+}
+
+// WriteFolded writes the profile as folded stacks, the input format of most
+// flame graph tools. Each line is a stack of function names separated by
+// semicolons, outermost first, followed by the number of nanoseconds spent
+// in the innermost function.
+func (p *Profile) WriteFolded(w io.Writer) error {
+	var err error
+	p.root.walk(nil, func(n *profNode, stack []string) {
+		var ns int64
+		for _, c := range n.counts {
+			ns += c.nanoseconds
+		}
+		if ns > 0 && err == nil {
+			_, err = fmt.Fprintf(w, "%s %d\n", strings.Join(stack, ";"), ns)
+		}
+	})
+	return err
+}
@@ -0,0 +1,117 @@
+package govm
+
+import (
+	"./codegen"
+	"./types"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestProfile(t *testing.T) {
+	code, err := fizzbuzz().Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := New()
+	v.Builtin(codegen.Sig(":string"), func(_ types.Caller, a ...types.Value) ([]types.Value, error) {
+		return nil, nil
+	})
+	v.Set("Println:string")
+	v.Profile = NewProfile()
+	if err := v.Load(code); err != nil {
+		t.Fatal("Load:", err)
+	}
+	v.Get("Main:")
+	if err := v.Call(); err != nil {
+		t.Fatal("Call:", err)
+	}
+
+	var folded bytes.Buffer
+	if err := v.Profile.WriteFolded(&folded); err != nil {
+		t.Fatal(err)
+	}
+	stacks := make(map[string]bool)
+	for _, line := range strings.Split(strings.TrimSpace(folded.String()), "\n") {
+		i := strings.LastIndexByte(line, ' ')
+		if i < 0 {
+			t.Fatal("Invalid folded stack:", line)
+		}
+		stacks[line[:i]] = true
+	}
+	for _, s := range []string{
+		"<toplevel>",
+		"<toplevel>;Main:",
+		"<toplevel>;Main:;fizzbuzz:int->string",
+		"<toplevel>;Main:;fizzbuzz:int->string;ToString:int->string",
+		"<toplevel>;Main:;Println:string",
+	} {
+		if !stacks[s] {
+			t.Errorf("Expected stack %q in profile:\n%s", s, folded.String())
+		}
+	}
+
+	// Each instruction executed is counted once
+	var total int64
+	v.Profile.root.walk(nil, func(n *profNode, _ []string) {
+		for _, c := range n.counts {
+			total += c.instructions
+		}
+	})
+	if total != 2434 {
+		t.Error("Expected 2434 instructions, got", total)
+	}
+
+	var pprof bytes.Buffer
+	if err := v.Profile.WritePprof(&pprof); err != nil {
+		t.Fatal(err)
+	}
+	z, err := gzip.NewReader(&pprof)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(z)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The first field is the empty string at the start of the string table
+	if !bytes.HasPrefix(data, []byte{6<<3 | 2, 0}) {
+		t.Errorf("Unexpected start of pprof profile % x", data[:2])
+	}
+	if !bytes.Contains(data, []byte("fizzbuzz:int->string")) {
+		t.Error("Expected function name in pprof profile")
+	}
+}
+
+// Builtins made from closures by the same code are told apart
+func TestProfileBuiltins(t *testing.T) {
+	v := New()
+	if err := v.RegisterFunc("Aaa", func(n int) int { return n }); err != nil {
+		t.Fatal(err)
+	}
+	if err := v.RegisterFunc("Bbb", strings.ToUpper); err != nil {
+		t.Fatal(err)
+	}
+	g := codegen.New()
+	g.Push("x")
+	g.Get("Bbb:string->string")
+	g.Call()
+	code, err := g.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	v.Profile = NewProfile()
+	if err := v.Load(code); err != nil {
+		t.Fatal(err)
+	}
+
+	var folded bytes.Buffer
+	if err := v.Profile.WriteFolded(&folded); err != nil {
+		t.Fatal(err)
+	}
+	if s := folded.String(); !strings.Contains(s, "<toplevel>;Bbb:string->string ") || strings.Contains(s, "Aaa") {
+		t.Errorf("Expected a call of Bbb only:\n%s", s)
+	}
+}
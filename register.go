@@ -0,0 +1,186 @@
+package govm
+
+import (
+	"fmt"
+	"reflect"
+	"./codegen"
+	"./types"
+)
+
+var (
+	errorType      = reflect.TypeOf((*error)(nil)).Elem()
+	errorValueType = reflect.TypeOf(types.Error{})
+)
+
+type RegisterError struct {
+	Type   reflect.Type
+	Reason string
+}
+
+func (e RegisterError) Error() string {
+	return fmt.Sprintf("Cannot use %s as a builtin: %s", e.Type, e.Reason)
+}
+
+// goType returns the VM type corresponding to a Go type. int, float64, bool,
+// string and types.Error map to the scalar types, and slices and maps of
+// them map to arrays and maps.
+func goType(t reflect.Type) (types.Type, error) {
+	if t == errorValueType {
+		return types.TypeErr, nil
+	}
+	switch t.Kind() {
+	case reflect.Int:
+		return types.TypeInt, nil
+	case reflect.Float64:
+		return types.TypeFloat, nil
+	case reflect.Bool:
+		return types.TypeBool, nil
+	case reflect.String:
+		return types.TypeString, nil
+	case reflect.Slice:
+		elem, err := goType(t.Elem())
+		if err != nil {
+			return elem, err
+		}
+		return types.Type{types.ArrayT, types.TypeSignature{}, 0, &elem, nil}, nil
+	case reflect.Map:
+		key, err := goType(t.Key())
+		if err != nil {
+			return key, err
+		}
+		switch key.Kind {
+		case types.Int, types.Float, types.Bool, types.String:
+		default:
+			return key, RegisterError{t, "map keys must be int, float64, bool or string"}
+		}
+		elem, err := goType(t.Elem())
+		if err != nil {
+			return elem, err
+		}
+		return types.Type{types.MapT, types.TypeSignature{}, 0, &elem, &key}, nil
+	}
+	return types.Type{}, RegisterError{t, "unsupported type"}
+}
+
+// toValue converts a Go value to a value of type t
+func toValue(rv reflect.Value, t types.Type) types.Value {
+	switch t.Kind {
+	case types.Int:
+		return int(rv.Int())
+	case types.Float:
+		return rv.Float()
+	case types.Bool:
+		return rv.Bool()
+	case types.String:
+		return rv.String()
+	case types.ErrorT:
+		return rv.Interface().(types.Error)
+	case types.ArrayT:
+		arr := types.Array{*t.Elem, nil}
+		if rv.Len() > 0 {
+			arr.V = make([]types.Value, rv.Len())
+			for i := range arr.V {
+				arr.V[i] = toValue(rv.Index(i), *t.Elem)
+			}
+		}
+		return arr
+	case types.MapT:
+		m := types.Map{*t.Key, *t.Elem, make(map[types.Value]types.Value, rv.Len())}
+		iter := rv.MapRange()
+		for iter.Next() {
+			m.M[toValue(iter.Key(), *t.Key)] = toValue(iter.Value(), *t.Elem)
+		}
+		return m
+	}
+	panic("unreachable")
+}
+
+// fromValue converts a value, which has already been type checked, to the Go
+// type gt
+func fromValue(val types.Value, gt reflect.Type) reflect.Value {
+	switch val := val.(type) {
+	case types.Array:
+		rv := reflect.MakeSlice(gt, len(val.V), len(val.V))
+		for i, elem := range val.V {
+			rv.Index(i).Set(fromValue(elem, gt.Elem()))
+		}
+		return rv
+	case types.Map:
+		rv := reflect.MakeMapWithSize(gt, len(val.M))
+		for k, elem := range val.M {
+			rv.SetMapIndex(fromValue(k, gt.Key()), fromValue(elem, gt.Elem()))
+		}
+		return rv
+	}
+	// Named types, such as type ID int, need converting
+	return reflect.ValueOf(val).Convert(gt)
+}
+
+// BuiltinOf wraps a Go function in a builtin. Its signature is derived from
+// the types of the function's parameters and results, as described by
+// goType. If the last result is an error, it isn't part of the signature, and
+// if it is non-nil it is thrown.
+func BuiltinOf(f interface{}) (types.Builtin, error) {
+	fv := reflect.ValueOf(f)
+	if !fv.IsValid() {
+		return types.Builtin{}, RegisterError{nil, "not a function"}
+	}
+	ft := fv.Type()
+	if ft.Kind() != reflect.Func {
+		return types.Builtin{}, RegisterError{ft, "not a function"}
+	}
+	if ft.IsVariadic() {
+		return types.Builtin{}, RegisterError{ft, "variadic functions are not supported"}
+	}
+
+	var sig types.TypeSignature
+	for i := 0; i < ft.NumIn(); i++ {
+		t, err := goType(ft.In(i))
+		if err != nil {
+			return types.Builtin{}, err
+		}
+		sig.Args = append(sig.Args, t)
+	}
+	nout := ft.NumOut()
+	throws := nout > 0 && ft.Out(nout-1) == errorType
+	if throws {
+		nout--
+	}
+	for i := 0; i < nout; i++ {
+		t, err := goType(ft.Out(i))
+		if err != nil {
+			return types.Builtin{}, err
+		}
+		sig.Ret = append(sig.Ret, t)
+	}
+
+	return types.NewBuiltin(sig, func(_ types.Caller, a ...types.Value) ([]types.Value, error) {
+		args := make([]reflect.Value, len(a))
+		for i, arg := range a {
+			args[i] = fromValue(arg, ft.In(i))
+		}
+		out := fv.Call(args)
+		if throws {
+			if err := out[nout].Interface(); err != nil {
+				return nil, err.(error)
+			}
+		}
+		rets := make([]types.Value, nout)
+		for i := range rets {
+			rets[i] = toValue(out[i], sig.Ret[i])
+		}
+		return rets, nil
+	}), nil
+}
+
+// RegisterFunc wraps a Go function in a builtin with BuiltinOf, and sets the
+// variable named by mangling name with the builtin's signature. For example,
+// registering func(int) string as "ToString" sets "ToString:int->string".
+func (v *VM) RegisterFunc(name string, f interface{}) error {
+	b, err := BuiltinOf(f)
+	if err != nil {
+		return err
+	}
+	v.scope.Set(codegen.Mangle(name, b.Sig), b)
+	return nil
+}
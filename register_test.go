@@ -0,0 +1,120 @@
+package govm
+
+import (
+	"./codegen"
+	"./types"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type celsius float64
+
+func TestRegisterFunc(t *testing.T) {
+	v := New()
+	funcs := map[string]interface{}{
+		"Repeat": strings.Repeat,
+		"Sum": func(xs []int) (n int) {
+			for _, x := range xs {
+				n += x
+			}
+			return
+		},
+		"Count": func(words []string) map[string]int {
+			m := make(map[string]int)
+			for _, w := range words {
+				m[w]++
+			}
+			return m
+		},
+		"Warm": func(c celsius) bool { return c > 20 },
+		"Check": func(ok bool) error {
+			if !ok {
+				return errors.New("check failed")
+			}
+			return nil
+		},
+	}
+	for name, f := range funcs {
+		if err := v.RegisterFunc(name, f); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	call := func(sym string, args ...types.Value) ([]types.Value, error) {
+		for _, arg := range args {
+			v.Push(arg)
+		}
+		if err := v.Get(types.Symbol(sym)); err != nil {
+			return nil, err
+		}
+		if err := v.Call(); err != nil {
+			return nil, err
+		}
+		stack := v.Stack()
+		v.stack = nil
+		return stack, nil
+	}
+
+	tests := []struct {
+		sym      string
+		args     []types.Value
+		expected []types.Value
+	}{
+		{"Repeat:string:int->string", []types.Value{"ab", 3}, []types.Value{"ababab"}},
+		{"Sum:[]int->int", []types.Value{types.Array{types.TypeInt, []types.Value{1, 2, 3}}}, []types.Value{6}},
+		{"Count:[]string->map[string]int", []types.Value{types.Array{types.TypeString, []types.Value{"a", "b", "a"}}},
+			[]types.Value{types.Map{types.TypeString, types.TypeInt, map[types.Value]types.Value{"a": 2, "b": 1}}}},
+		{"Warm:float->bool", []types.Value{25.0}, []types.Value{true}},
+		{"Check:bool", []types.Value{true}, nil},
+	}
+	for _, test := range tests {
+		rets, err := call(test.sym, test.args...)
+		if err != nil {
+			t.Errorf("%s: %v", test.sym, err)
+			continue
+		}
+		if !reflect.DeepEqual(rets, test.expected) {
+			t.Errorf("%s: expected %v, got %v", test.sym, test.expected, rets)
+		}
+	}
+
+	// A trailing error result is thrown
+	_, err := call("Check:bool", false)
+	var e types.Error
+	if !errors.As(err, &e) || e.Err.Error() != "check failed" {
+		t.Error("Expected check failed error, got", err)
+	}
+
+	// The builtin's signature is checked when it is called
+	v.stack = nil
+	if _, err := call("Sum:[]int->int", types.Array{types.TypeString, nil}); err == nil {
+		t.Error("Expected type error calling Sum with []string")
+	}
+}
+
+func TestRegisterFuncErrors(t *testing.T) {
+	v := New()
+	for _, f := range []interface{}{
+		nil,
+		42,
+		func(int32) {},
+		func(...int) {},
+		func() map[types.Error]int { return nil },
+		func() (error, int) { return nil, 0 },
+	} {
+		if err := v.RegisterFunc("F", f); !errors.As(err, new(RegisterError)) {
+			t.Errorf("Expected RegisterError for %T, got %v", f, err)
+		}
+	}
+}
+
+func TestMangle(t *testing.T) {
+	for _, sym := range []string{"Main:", "ToString:int->string", "F:[]int:func(:int->bool)", "G:->map[string]int:error"} {
+		i := strings.IndexByte(sym, ':')
+		if mangled := codegen.Mangle(sym[:i], codegen.Sig(sym[i+1:])); string(mangled) != sym {
+			t.Errorf("Expected %s, got %s", sym, mangled)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package stdlib
 
 import (
+	"errors"
 	"fmt"
 	"strconv"
 	"strings"
@@ -10,7 +11,7 @@ import (
 
 type FuncDef struct {
 	name string
-	f    func(...types.Value) []types.Value
+	f    func(types.Caller, ...types.Value) ([]types.Value, error)
 }
 
 func (d FuncDef) Name() types.Symbol {
@@ -23,25 +24,41 @@ func values(args ...types.Value) []types.Value {
 
 func (d FuncDef) Builtin() types.Builtin {
 	sig := codegen.Sig(strings.SplitN(d.name, ":", 2)[1])
-	return types.Builtin{sig, d.f}
+	return types.NewBuiltin(sig, d.f)
 }
 
 var Functions = []FuncDef{
-	FuncDef{"Println:string", func(a... types.Value) []types.Value {
+	FuncDef{"Println:string", func(c types.Caller, a... types.Value) ([]types.Value, error) {
 		s := a[0].(string)
-		fmt.Println(s)
-		return nil
+		_, stdout, _ := c.Streams()
+		_, err := fmt.Fprintln(stdout, s)
+		return nil, err
 	}},
 
-	// FIXME: errors are not yet implemented
-	//FuncDef{"ToInt:string->int:error", func(a... types.Value) []types.Value {
-	//	s := a[0].(string)
-	//	return values(strconv.Atoi(s))
-	//}},
+	FuncDef{"ToInt:string->int:error", func(_ types.Caller, a... types.Value) ([]types.Value, error) {
+		s := a[0].(string)
+		i, err := strconv.Atoi(s)
+		return values(i, types.Error{err}), nil
+	}},
 
-	FuncDef{"ToString:int->string", func(a... types.Value) []types.Value {
+	FuncDef{"ToString:int->string", func(_ types.Caller, a... types.Value) ([]types.Value, error) {
 		i := a[0].(int)
-		return values(strconv.Itoa(i))
+		return values(strconv.Itoa(i)), nil
+	}},
+
+	FuncDef{"Error:string->error", func(_ types.Caller, a... types.Value) ([]types.Value, error) {
+		s := a[0].(string)
+		return values(types.Error{errors.New(s)}), nil
+	}},
+
+	FuncDef{"IsNil:error->bool", func(_ types.Caller, a... types.Value) ([]types.Value, error) {
+		e := a[0].(types.Error)
+		return values(e.Err == nil), nil
+	}},
+
+	FuncDef{"ToString:error->string", func(_ types.Caller, a... types.Value) ([]types.Value, error) {
+		e := a[0].(types.Error)
+		return values(e.Error()), nil
 	}},
 
 	// TODO: many more stdlib functions need implemented
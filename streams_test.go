@@ -0,0 +1,49 @@
+package govm
+
+import (
+	"./asm"
+	"./codegen"
+	"./types"
+	"bufio"
+	"bytes"
+	"strings"
+	"testing"
+)
+
+const streamsSrc = `
+get @ReadLine:->string
+call
+get @Println:string
+call
+`
+
+func TestStreams(t *testing.T) {
+	g, err := asm.Assemble(strings.NewReader(streamsSrc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, err := g.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Each VM reads and writes its own streams
+	var outs [2]bytes.Buffer
+	for i, in := range []string{"one\n", "two\n"} {
+		v := New()
+		v.Builtin(codegen.Sig(":->string"), func(c types.Caller, _ ...types.Value) ([]types.Value, error) {
+			stdin, _, _ := c.Streams()
+			line, err := bufio.NewReader(stdin).ReadString('\n')
+			return []types.Value{strings.TrimSuffix(line, "\n")}, err
+		})
+		v.Set("ReadLine:->string")
+		v.Stdin = strings.NewReader(in)
+		v.Stdout = &outs[i]
+		if err := v.Load(code); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if outs[0].String() != "one\n" || outs[1].String() != "two\n" {
+		t.Errorf("Expected one and two, got %q and %q", outs[0].String(), outs[1].String())
+	}
+}
@@ -0,0 +1,123 @@
+package govm
+
+import (
+	"./bytecode"
+	"./codegen"
+	"./types"
+	"errors"
+	"testing"
+)
+
+func TestStruct(t *testing.T) {
+	g := codegen.New()
+	point := g.Struct("Point", codegen.Types("int:string"))
+	g.Push(1)
+	g.Push("one")
+	g.New(point)
+	g.Dup()
+	g.Push(2)
+	g.FSet(0)
+	g.Dup()
+	g.FGet(1)
+	g.Set("name")
+	g.FGet(0)
+	g.Set("num")
+
+	code, err := g.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := New()
+	if err := v.Load(code); err != nil {
+		t.Fatal("Load:", err)
+	}
+
+	if err := v.Get("num"); err != nil {
+		t.Fatal(err)
+	}
+	if num, _ := v.Pop(); num != 2 {
+		t.Error("Expected field 0 to be 2, got", num)
+	}
+	if err := v.Get("name"); err != nil {
+		t.Fatal(err)
+	}
+	if name, _ := v.Pop(); name != "one" {
+		t.Error("Expected field 1 to be \"one\", got", name)
+	}
+
+	// Setting a field to the wrong type is a type error
+	v.Push(types.StructValue{point, []types.Value{1, "one"}})
+	v.Push(1.5)
+	if _, ok := v.FSet(1).(types.TypeError); !ok {
+		t.Error("Expected type error from fset")
+	}
+}
+
+func TestStructTable(t *testing.T) {
+	gen := func(body func(g *codegen.Generator)) []byte {
+		g := codegen.New()
+		body(&g)
+		code, err := g.Generate()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return code
+	}
+	v := New()
+
+	// Each file's struct indices are rebased into the VM's table
+	a := gen(func(g *codegen.Generator) {
+		g.Struct("A", codegen.Types("int"))
+	})
+	if err := v.Load(a); err != nil {
+		t.Fatal(err)
+	}
+	b := gen(func(g *codegen.Generator) {
+		g.New(g.Struct("B", codegen.Types("string")))
+	})
+	v.Push("x")
+	if err := v.Load(b); err != nil {
+		t.Fatal(err)
+	}
+	if val, _ := v.Pop(); types.TypeOf(val).I != 1 {
+		t.Error("Expected struct 1, got", val)
+	}
+
+	// Loading the same structs again doesn't add to the table, but unnamed
+	// structs are always added
+	for i := 0; i < 3; i++ {
+		if err := v.Load(b); err == nil {
+			t.Error("Expected stack underflow")
+		}
+	}
+	if n := len(v.Structs()); n != 2 {
+		t.Error("Expected 2 structs, got", n)
+	}
+	anon := gen(func(g *codegen.Generator) {
+		g.Struct("", codegen.Types("int"))
+	})
+	if err := v.Load(anon); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(v.Structs()); n != 3 {
+		t.Error("Expected 3 structs, got", n)
+	}
+
+	// Indices outside the file's struct table are rejected when it is loaded
+	bad := gen(func(g *codegen.Generator) {
+		g.Struct("C", codegen.Types("int"))
+		g.New(1)
+	})
+	if err := v.Load(bad); !errors.As(err, new(bytecode.PoolError)) {
+		t.Error("Expected pool error, got", err)
+	}
+	bad = gen(func(g *codegen.Generator) {
+		g.Struct("D", codegen.Types("struct(0)"))
+	})
+	if err := v.Load(bad); !errors.As(err, new(bytecode.SectionError)) {
+		t.Error("Expected section error, got", err)
+	}
+	if n := len(v.Structs()); n != 3 {
+		t.Error("Expected failed loads not to add structs, got", n)
+	}
+}
@@ -0,0 +1,49 @@
+package types
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Pos is a position in a source file. Lines and columns start at 1, and a
+// zero Pos is unknown
+type Pos struct {
+	File      string
+	Line, Col int
+}
+
+func (p Pos) String() string {
+	if p.File == "" {
+		return fmt.Sprintf("%d:%d", p.Line, p.Col)
+	}
+	return fmt.Sprintf("%s:%d:%d", p.File, p.Line, p.Col)
+}
+
+// DebugInfo maps offsets in the code section of a file to the positions in
+// the source that its instructions were generated from
+type DebugInfo struct {
+	Offsets []int // In ascending order
+	Pos     []Pos
+}
+
+// Add sets the position of the code from off onwards. Offsets must be added
+// in ascending order.
+func (d *DebugInfo) Add(off int, p Pos) {
+	if n := len(d.Pos); n > 0 && d.Pos[n-1] == p {
+		return
+	}
+	d.Offsets = append(d.Offsets, off)
+	d.Pos = append(d.Pos, p)
+}
+
+// Lookup returns the position of the instruction at off
+func (d *DebugInfo) Lookup(off int) (Pos, bool) {
+	if d == nil {
+		return Pos{}, false
+	}
+	i := sort.SearchInts(d.Offsets, off+1) - 1
+	if i < 0 {
+		return Pos{}, false
+	}
+	return d.Pos[i], true
+}
@@ -1,6 +1,9 @@
 package types
 
-import "fmt"
+import (
+	"fmt"
+	"strings"
+)
 
 type TypeError struct{ Expected, Actual Type }
 
@@ -18,11 +21,73 @@ func (t Type) String() string {
 		return "bool"
 	case String:
 		return "string"
+	case FuncT:
+		return "func(" + t.Sig.String() + ")"
 	case Struct:
-		panic("Structs not implemented")
-	default:
-		panic("Unknown type")
+		if t.I < 0 {
+			return "struct"
+		}
+		return fmt.Sprintf("struct(%d)", t.I)
+	case ArrayT:
+		if t.Elem == nil {
+			return "array"
+		}
+		return "[]" + t.Elem.String()
+	case ErrorT:
+		return "error"
+	case MapT:
+		if t.Key == nil || t.Elem == nil {
+			return "map"
+		}
+		return "map[" + t.Key.String() + "]" + t.Elem.String()
 	}
+
+	// Combinations of kinds, such as TypeNum
+	var kinds []string
+	for _, k := range []Kind{Int, Float, Bool, String} {
+		if t.Kind&k != 0 {
+			kinds = append(kinds, Type{k, TypeSignature{}, 0, nil, nil}.String())
+		}
+	}
+	if len(kinds) > 1 && t.Kind&^(Int|Float|Bool|String) == 0 {
+		return strings.Join(kinds, "|")
+	}
+	return fmt.Sprintf("invalid(%#02x)", byte(t.Kind))
+}
+
+type ValueError struct{ Value Value }
+
+func (e ValueError) Error() string {
+	return fmt.Sprintf("Value error: %v (Go type %T) is not a valid value", e.Value, e.Value)
+}
+
+func (ts TypeSignature) String() string {
+	s := ""
+	for _, t := range ts.Args {
+		s += ":" + t.String()
+	}
+	if len(ts.Ret) > 0 {
+		if s == "" {
+			s = ":"
+		}
+		s += "->"
+		for i, t := range ts.Ret {
+			if i > 0 {
+				s += ":"
+			}
+			s += t.String()
+		}
+	}
+	if s == "" {
+		s = ":"
+	}
+	return s
+}
+
+type ZeroError struct{ Type Type }
+
+func (e ZeroError) Error() string {
+	return fmt.Sprintf("Type error: %s has no zero value", e.Type)
 }
 
 type NameError struct{ Name Symbol }
@@ -31,12 +96,35 @@ func (e NameError) Error() string {
 	return fmt.Sprintf("Name error: could not find variable named %s", e.Name)
 }
 
+type IndexError struct{ Index, Len int }
+
+func (e IndexError) Error() string {
+	return fmt.Sprintf("Index error: index %d out of range [0:%d]", e.Index, e.Len)
+}
+
+type LocalError struct{ N int }
+
+func (e LocalError) Error() string {
+	return fmt.Sprintf("Name error: local %d used before being set", e.N)
+}
+
 type StackUnderflow struct{}
 
 func (e StackUnderflow) Error() string {
 	return "Stack underflow"
 }
 
+// StackOverflow is returned when a VM's limit on the size of the operand
+// stack, or on the depth of nested calls if Calls is set, is exceeded
+type StackOverflow struct{ Calls bool }
+
+func (e StackOverflow) Error() string {
+	if e.Calls {
+		return "Stack overflow: too many nested calls"
+	}
+	return "Stack overflow"
+}
+
 type ReturnError struct{}
 
 func (r ReturnError) Error() string {
@@ -0,0 +1,49 @@
+package types
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Format formats a value for display, such as in a debugger
+func Format(val Value) string {
+	switch val := val.(type) {
+	case int:
+		return strconv.Itoa(val)
+	case float64:
+		return strconv.FormatFloat(val, 'g', -1, 64)
+	case bool:
+		return strconv.FormatBool(val)
+	case string:
+		return strconv.Quote(val)
+	case Function, Builtin:
+		return TypeOf(val).String()
+	case StructValue:
+		return TypeOf(val).String() + "{" + formatList(val.Fields) + "}"
+	case Array:
+		return TypeOf(val).String() + "{" + formatList(val.V) + "}"
+	case Map:
+		entries := make([]string, 0, len(val.M))
+		for k, v := range val.M {
+			entries = append(entries, Format(k)+": "+Format(v))
+		}
+		sort.Strings(entries)
+		return TypeOf(val).String() + "{" + strings.Join(entries, ", ") + "}"
+	case Error:
+		if val.Err == nil {
+			return "error(nil)"
+		}
+		return "error(" + strconv.Quote(val.Err.Error()) + ")"
+	default:
+		return "invalid"
+	}
+}
+
+func formatList(vals []Value) string {
+	s := make([]string, len(vals))
+	for i, v := range vals {
+		s[i] = Format(v)
+	}
+	return strings.Join(s, ", ")
+}
@@ -1,5 +1,10 @@
 package types
 
+import (
+	"io"
+	"sort"
+)
+
 type Value interface{}
 
 type Symbol string
@@ -15,21 +20,37 @@ const (
 	Struct
 )
 
+// Composite kinds are not bit flags, so they cannot be combined like Int|Float
+const (
+	ArrayT Kind = 0x40 + iota
+	MapT
+	ErrorT
+)
+
 type Type struct {
 	Kind Kind
 	Sig  TypeSignature // Only used when Kind is Func
 	I    int           // Index in struct table. Only used when Kind is Struct
+	Elem *Type         // Element type. Only used when Kind is Array or Map
+	Key  *Type         // Key type. Only used when Kind is Map
 }
 
 var (
-	TypeInt    Type = Type{Int, TypeSignature{}, 0}
-	TypeFloat  Type = Type{Float, TypeSignature{}, 0}
-	TypeNum    Type = Type{Int | Float, TypeSignature{}, 0}
-	TypeBool   Type = Type{Bool, TypeSignature{}, 0}
-	TypeString Type = Type{String, TypeSignature{}, 0}
-	TypeFunc   Type = Type{FuncT, TypeSignature{}, 0}
+	TypeInt    Type = Type{Int, TypeSignature{}, 0, nil, nil}
+	TypeFloat  Type = Type{Float, TypeSignature{}, 0, nil, nil}
+	TypeNum    Type = Type{Int | Float, TypeSignature{}, 0, nil, nil}
+	TypeBool   Type = Type{Bool, TypeSignature{}, 0, nil, nil}
+	TypeString Type = Type{String, TypeSignature{}, 0, nil, nil}
+	TypeFunc   Type = Type{FuncT, TypeSignature{}, 0, nil, nil}
+	TypeStruct Type = Type{Struct, TypeSignature{}, -1, nil, nil} // Not a real type. Only used in errors
+	TypeArray  Type = Type{ArrayT, TypeSignature{}, 0, nil, nil}  // Not a real type. Only used in errors
+	TypeMap    Type = Type{MapT, TypeSignature{}, 0, nil, nil}    // Not a real type. Only used in errors
+	TypeKey    Type = Type{Int | Float | Bool | String, TypeSignature{}, 0, nil, nil}
+	TypeErr    Type = Type{ErrorT, TypeSignature{}, 0, nil, nil}
 )
 
+// TypeOf returns the type of a value. If val is not a valid value, the
+// returned type has a Kind of 0, which TypeCheck reports as a ValueError
 func TypeOf(val Value) (t Type) {
 	switch val := val.(type) {
 	case int:
@@ -48,13 +69,31 @@ func TypeOf(val Value) (t Type) {
 		t.Kind = FuncT
 		t.Sig = val.Sig
 		return
+	case StructValue:
+		t.Kind = Struct
+		t.I = val.I
+		return
+	case Array:
+		t.Kind = ArrayT
+		t.Elem = &val.Elem
+		return
+	case Map:
+		t.Kind = MapT
+		t.Key = &val.Key
+		t.Elem = &val.Elem
+		return
+	case Error:
+		return TypeErr
 	default:
-		panic("Unknown type")
+		return
 	}
 }
 
 func (t Type) TypeCheck(val Value) error {
 	t2 := TypeOf(val)
+	if t2.Kind == 0 {
+		return ValueError{val}
+	}
 	if t.Equal(t2) {
 		return nil
 	} else {
@@ -63,8 +102,22 @@ func (t Type) TypeCheck(val Value) error {
 }
 
 func (t Type) Equal(t2 Type) bool {
+	if t.Kind >= ArrayT || t2.Kind >= ArrayT {
+		if t.Kind != t2.Kind {
+			return false
+		}
+		switch t.Kind {
+		case ArrayT:
+			return t.Elem != nil && t2.Elem != nil && t.Elem.Equal(*t2.Elem)
+		case MapT:
+			return t.Key != nil && t2.Key != nil && t.Key.Equal(*t2.Key) &&
+				t.Elem != nil && t2.Elem != nil && t.Elem.Equal(*t2.Elem)
+		}
+		return true
+	}
+
 	if t2.Kind == Struct {
-		panic("Structs not yet implemented")
+		return t.Kind == Struct && t.I == t2.I
 	} else if t.Kind&t2.Kind != 0 {
 		if t2.Kind == FuncT {
 			if t.Sig.Equal(t2.Sig) {
@@ -86,26 +139,128 @@ func (ts TypeSignature) Equal(ts2 TypeSignature) bool {
 		return false
 	}
 	for i := range ts.Args {
-		if ts.Args[i].Equal(ts2.Args[i]) {
+		if !ts.Args[i].Equal(ts2.Args[i]) {
 			return false
 		}
 	}
 	for i := range ts.Ret {
-		if ts.Ret[i].Equal(ts2.Ret[i]) {
+		if !ts.Ret[i].Equal(ts2.Ret[i]) {
 			return false
 		}
 	}
 	return true
 }
 
+// Instruction is a decoded instruction. Depending on the opcode, its operand
+// is stored in either Arg or Val
+type Instruction struct {
+	Op     byte
+	Offset int   // Offset of the instruction in the code it was decoded from
+	Arg    int   // Integer operand. For jumps, the index of the target instruction
+	Val    Value // Any other operand, such as a constant, symbol or type
+}
+
 type Function struct {
-	Sig  TypeSignature
-	Code []byte
+	Sig    TypeSignature
+	Locals int // Number of local variable slots
+	Code   []Instruction
+	Env    *Scope     // The scope the function was created in. Calls create a child of this scope
+	Debug  *DebugInfo // Debug info of the file the function was loaded from, if any
 }
 
+type StructValue struct {
+	I      int // Index in struct table
+	Fields []Value
+}
+
+// StructDef is an entry in the struct table. Name is only used to identify
+// the same struct in code loaded from different files, and may be empty.
+type StructDef struct {
+	Name   string
+	Fields []Type
+}
+
+// Zero returns the zero value of a type, if it has one. Each call returns a
+// new value, so zero maps are never shared
+func (t Type) Zero() (Value, bool) {
+	switch t.Kind {
+	case Int:
+		return 0, true
+	case Float:
+		return 0.0, true
+	case Bool:
+		return false, true
+	case String:
+		return "", true
+	case ArrayT:
+		return Array{*t.Elem, nil}, true
+	case MapT:
+		return Map{*t.Key, *t.Elem, make(map[Value]Value)}, true
+	case ErrorT:
+		return Error{nil}, true
+	default:
+		return nil, false
+	}
+}
+
+type Array struct {
+	Elem Type
+	V    []Value
+}
+
+type Map struct {
+	Key, Elem Type
+	M         map[Value]Value
+}
+
+// Error is the value of the error type. It is also returned as a Go error
+// when thrown. An Error with a nil Err represents the absence of an error.
+type Error struct{ Err error }
+
+func (e Error) Error() string {
+	if e.Err == nil {
+		return "nil error"
+	}
+	return e.Err.Error()
+}
+
+func (e Error) Unwrap() error {
+	return e.Err
+}
+
+// A Builtin's F may return a non-nil error to throw it. If the error is not
+// already an Error, it is wrapped in one. F is passed the VM running it as a
+// Caller, so that it can call function values passed to it. Builtins are
+// created with NewBuiltin.
 type Builtin struct {
 	Sig TypeSignature
-	F   func(...Value) []Value
+	F   func(c Caller, args ...Value) ([]Value, error)
+	id  *byte
+}
+
+func NewBuiltin(sig TypeSignature, f func(c Caller, args ...Value) ([]Value, error)) Builtin {
+	return Builtin{sig, f, new(byte)}
+}
+
+// ID identifies a builtin. Copies of a builtin have the same ID, and
+// builtins created separately have different IDs, even if their F is the
+// same function, as with closures created by the same code.
+func (b Builtin) ID() *byte {
+	return b.id
+}
+
+// Caller is the VM running a builtin. It calls function values from Go by
+// re-entering the VM, and gives the streams the builtin should use for I/O.
+type Caller interface {
+	// CallValue calls f with args and returns its results. The args must
+	// have the types in f's signature. Errors thrown by f and not caught are
+	// returned, and if a builtin returns such an error it is thrown again
+	// from the builtin's caller. Other errors can't be caught.
+	CallValue(f Value, args ...Value) ([]Value, error)
+
+	// Streams returns the standard input, output and error streams that
+	// builtins should use instead of those in package os
+	Streams() (stdin io.Reader, stdout, stderr io.Writer)
 }
 
 type Stack []Value
@@ -174,6 +329,17 @@ func (s *Scope) Set(k Symbol, v Value) {
 	s.m[k] = v
 }
 
+// Names returns the names of the variables set in s, but not its parents,
+// in sorted order
+func (s *Scope) Names() []Symbol {
+	names := make([]Symbol, 0, len(s.m))
+	for k := range s.m {
+		names = append(names, k)
+	}
+	sort.Slice(names, func(i, j int) bool { return names[i] < names[j] })
+	return names
+}
+
 func (s *Scope) Get(k Symbol) (Value, error) {
 	if s.m != nil {
 		if v, ok := s.m[k]; ok {
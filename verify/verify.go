@@ -0,0 +1,614 @@
+// Package verify checks bytecode before it is run, by abstractly
+// interpreting each function with the types of the values on its stack
+// rather than the values themselves.
+//
+// The verifier is stricter than the VM, so it rejects some programs that
+// run without errors:
+//
+//   - A function must return with exactly its results on the stack. The VM
+//     only checks the values on top, and leaves any below them on the
+//     caller's stack, as examples/fizzbuzz.gva does.
+//   - Calling a function from a variable without a type signature in its
+//     name, such as one set with set @f, is an UnknownCallError, since the
+//     effect of the call on the stack isn't known.
+//   - The type of a variable is inferred from its name, so a variable whose
+//     name contains a colon must hold a function with that signature.
+package verify
+
+import (
+	"fmt"
+	"strings"
+	"../bytecode"
+	"../codegen"
+	"../opcode"
+	"../types"
+)
+
+// Error is a verification failure at an offset in the code
+type Error struct {
+	Offset int
+	Err    error
+}
+
+func (e Error) Error() string {
+	return fmt.Sprintf("Verify error at offset %d: %s", e.Offset, e.Err)
+}
+
+func (e Error) Unwrap() error {
+	return e.Err
+}
+
+// MergeError is returned when two paths reach the same instruction with
+// different numbers of values on the stack
+type MergeError struct{ A, B []types.Type }
+
+func (e MergeError) Error() string {
+	return fmt.Sprintf("Stack mismatch: %s and %s", stackString(e.A), stackString(e.B))
+}
+
+// ResultError is returned when a function returns with a stack that doesn't
+// match the results in its type signature
+type ResultError struct{ Expected, Actual []types.Type }
+
+func (e ResultError) Error() string {
+	return fmt.Sprintf("Type error: expected to return %s, got %s", stackString(e.Expected), stackString(e.Actual))
+}
+
+// UnknownCallError is returned when the type of a called function can't be
+// determined, so neither can the effect of the call on the stack
+type UnknownCallError struct{}
+
+func (e UnknownCallError) Error() string {
+	return "Call of function with unknown type"
+}
+
+// The type of values that can't be determined statically, such as those of
+// variables without a type signature in their name. It is compatible with
+// every other type.
+var unknown = types.Type{}
+
+func stackString(ts []types.Type) string {
+	s := make([]string, len(ts))
+	for i, t := range ts {
+		if t.Kind == 0 {
+			s[i] = "?"
+		} else {
+			s[i] = t.String()
+		}
+	}
+	return "[" + strings.Join(s, " ") + "]"
+}
+
+// File decodes and verifies a GVB file, as it would be loaded into a new VM
+func File(data []byte) error {
+	f, err := bytecode.ParseFile(data)
+	if err != nil {
+		return err
+	}
+	defs, err := bytecode.ReadStructs(f)
+	if err != nil {
+		return err
+	}
+	// As if loaded into a new VM
+	structs, index := bytecode.LinkStructs(nil, defs)
+	code, _, err := bytecode.DecodeFile(f, index)
+	if err != nil {
+		return err
+	}
+	return Code(code, structs, nil)
+}
+
+// Code verifies decoded top level code, including the bodies of the
+// functions it creates, with the struct table that its struct indices refer
+// to and the types of the values already on the stack, bottom first. Jump
+// targets are checked by bytecode.Decode.
+func Code(code []types.Instruction, structs []types.StructDef, stack []types.Type) error {
+	v := verifier{structs}
+	// The values on the stack are treated like arguments
+	return v.function(types.TypeSignature{stack, nil}, 0, code, true)
+}
+
+type verifier struct {
+	structs []types.StructDef
+}
+
+// state is the types of the values on the stack and in the local variable
+// slots before an instruction
+type state struct {
+	stack  []types.Type
+	locals []types.Type
+}
+
+func (s state) copy() state {
+	return state{append([]types.Type(nil), s.stack...), append([]types.Type(nil), s.locals...)}
+}
+
+func (s *state) push(t types.Type) {
+	s.stack = append(s.stack, t)
+}
+
+func (s *state) pop(want types.Type) (types.Type, error) {
+	if len(s.stack) == 0 {
+		return unknown, types.StackUnderflow{}
+	}
+	t := s.stack[len(s.stack)-1]
+	s.stack = s.stack[:len(s.stack)-1]
+	if !compatible(want, t) {
+		return t, types.TypeError{want, t}
+	}
+	return t, nil
+}
+
+// merge merges s2 into s, returning whether s changed
+func (s *state) merge(s2 state) (bool, error) {
+	if len(s.stack) != len(s2.stack) {
+		return false, MergeError{s.stack, s2.stack}
+	}
+	changed := false
+	for i := range s.stack {
+		if t := join(s.stack[i], s2.stack[i]); !same(t, s.stack[i]) {
+			s.stack[i] = t
+			changed = true
+		}
+	}
+	for i := range s.locals {
+		if t := join(s.locals[i], s2.locals[i]); !same(t, s.locals[i]) {
+			s.locals[i] = t
+			changed = true
+		}
+	}
+	return changed, nil
+}
+
+// function verifies the body of a function with the given signature. At the
+// top level, the stack may be left in any state.
+func (v *verifier) function(sig types.TypeSignature, locals int, code []types.Instruction, top bool) error {
+	states := make([]*state, len(code)+1)
+	states[0] = &state{append([]types.Type(nil), sig.Args...), make([]types.Type, locals)}
+	work := []int{0}
+	verified := make(map[int]bool) // Instructions whose function bodies have been verified
+
+	// flow merges s into the state before target, queueing target if it changed
+	flow := func(target int, s state) error {
+		if states[target] == nil {
+			s = s.copy()
+			states[target] = &s
+			work = append(work, target)
+			return nil
+		}
+		changed, err := states[target].merge(s)
+		if changed {
+			work = append(work, target)
+		}
+		return err
+	}
+	// ret checks the stack when the function returns
+	ret := func(s state) error {
+		if top {
+			return nil
+		}
+		if len(s.stack) != len(sig.Ret) {
+			return ResultError{sig.Ret, s.stack}
+		}
+		for i, t := range sig.Ret {
+			if !compatible(t, s.stack[i]) {
+				return ResultError{sig.Ret, s.stack}
+			}
+		}
+		return nil
+	}
+
+	for len(work) > 0 {
+		pc := work[len(work)-1]
+		work = work[:len(work)-1]
+		if pc == len(code) {
+			continue
+		}
+		in := code[pc]
+		s := states[pc].copy()
+		next, err := v.instruction(pc, in, &s, verified[pc])
+		if err == nil && in.Op == opcode.Func {
+			verified[pc] = true
+		}
+		if err == nil && in.Op == opcode.Try {
+			// The stack is truncated to its current size and the error
+			// pushed. Locals may have been set anywhere in the region.
+			catch := state{append(s.copy().stack, types.TypeErr), make([]types.Type, locals)}
+			err = flow(in.Arg, catch)
+		}
+		if err == nil {
+			for _, target := range next {
+				if target == len(code) {
+					if err = ret(s); err != nil {
+						break
+					}
+				}
+				if err = flow(target, s); err != nil {
+					break
+				}
+			}
+		}
+		if err == nil && in.Op == opcode.Ret {
+			err = ret(s)
+		}
+		if err != nil {
+			if _, ok := err.(Error); ok {
+				// From the body of a function
+				return err
+			}
+			return Error{in.Offset, err}
+		}
+	}
+	return nil
+}
+
+// instruction applies the effect of the instruction at index pc to s,
+// returning the indices of the instructions that may be run next. The body
+// of a func instruction is verified unless verified is set.
+func (v *verifier) instruction(pc int, in types.Instruction, s *state, verified bool) ([]int, error) {
+	next := []int{pc + 1}
+	var err error
+	// pop pops values of the given types, the last of which is at the top
+	// of the stack, stopping at the first error
+	pop := func(want ...types.Type) []types.Type {
+		got := make([]types.Type, len(want))
+		for i := len(want) - 1; i >= 0 && err == nil; i-- {
+			got[i], err = s.pop(want[i])
+		}
+		return got
+	}
+
+	switch in.Op {
+	case opcode.J:
+		next = []int{in.Arg}
+	case opcode.JT, opcode.JF:
+		pop(types.TypeBool)
+		next = append(next, in.Arg)
+	case opcode.JZ, opcode.JNz:
+		pop(types.TypeNum)
+		next = append(next, in.Arg)
+
+	case opcode.Push:
+		s.push(types.TypeOf(in.Val))
+	case opcode.Pop:
+		pop(unknown)
+	case opcode.Dup:
+		t := pop(unknown)
+		s.push(t[0])
+		s.push(t[0])
+	case opcode.Swp:
+		t := pop(unknown, unknown)
+		s.push(t[1])
+		s.push(t[0])
+	case opcode.Set:
+		pop(symbolType(in.Val.(types.Symbol)))
+	case opcode.Get:
+		s.push(symbolType(in.Val.(types.Symbol)))
+	case opcode.LSet:
+		if in.Arg < 0 || in.Arg >= len(s.locals) {
+			return nil, types.IndexError{in.Arg, len(s.locals)}
+		}
+		t := pop(unknown)
+		s.locals[in.Arg] = t[0]
+	case opcode.LGet:
+		if in.Arg < 0 || in.Arg >= len(s.locals) {
+			return nil, types.IndexError{in.Arg, len(s.locals)}
+		}
+		s.push(s.locals[in.Arg])
+
+	case opcode.Inc, opcode.Dec, opcode.BNot:
+		pop(types.TypeInt)
+		s.push(types.TypeInt)
+	case opcode.Add, opcode.Sub, opcode.Mul, opcode.Div:
+		t := pop(types.TypeNum, types.TypeNum)
+		switch {
+		case t[0].Kind == types.Int && t[1].Kind == types.Int:
+			s.push(types.TypeInt)
+		case t[0].Kind == types.Float || t[1].Kind == types.Float:
+			s.push(types.TypeFloat)
+		default:
+			s.push(types.TypeNum)
+		}
+	case opcode.Mod, opcode.BAnd, opcode.BOr, opcode.BXor, opcode.BLS, opcode.BRS,
+		opcode.BSet, opcode.BClr, opcode.BTgl:
+		pop(types.TypeInt, types.TypeInt)
+		s.push(types.TypeInt)
+	case opcode.BMtch:
+		pop(types.TypeInt, types.TypeInt)
+		s.push(types.TypeBool)
+	case opcode.EQ, opcode.NE, opcode.LT, opcode.GT, opcode.LE, opcode.GE:
+		pop(types.TypeNum, types.TypeNum)
+		s.push(types.TypeBool)
+	case opcode.And, opcode.Or, opcode.Xor:
+		pop(types.TypeBool, types.TypeBool)
+		s.push(types.TypeBool)
+	case opcode.Not:
+		pop(types.TypeBool)
+		s.push(types.TypeBool)
+
+	case opcode.Call:
+		f := pop(unknown)[0]
+		if err != nil {
+			return nil, err
+		}
+		if f.Kind == 0 {
+			return nil, UnknownCallError{}
+		}
+		if f.Kind != types.FuncT {
+			return nil, types.TypeError{types.TypeFunc, f}
+		}
+		pop(f.Sig.Args...)
+		for _, t := range f.Sig.Ret {
+			s.push(t)
+		}
+	case opcode.Ret:
+		next = nil
+	case opcode.Func:
+		f := in.Val.(types.Function)
+		if !verified {
+			if err := v.function(f.Sig, f.Locals, f.Code, false); err != nil {
+				return nil, err
+			}
+		}
+		s.push(types.Type{types.FuncT, f.Sig, 0, nil, nil})
+
+	case opcode.New:
+		if in.Arg < 0 || in.Arg >= len(v.structs) {
+			return nil, types.IndexError{in.Arg, len(v.structs)}
+		}
+		pop(v.structs[in.Arg].Fields...)
+		s.push(types.Type{types.Struct, types.TypeSignature{}, in.Arg, nil, nil})
+	case opcode.FGet:
+		t := v.field(pop(unknown)[0], in.Arg, &err)
+		s.push(t)
+	case opcode.FSet:
+		t := pop(unknown, unknown)
+		if f := v.field(t[0], in.Arg, &err); err == nil && !compatible(f, t[1]) {
+			err = types.TypeError{f, t[1]}
+		}
+
+	case opcode.Make:
+		t := in.Val.(types.Type)
+		switch t.Kind {
+		case types.ArrayT:
+			pop(types.TypeInt)
+		case types.MapT:
+			if !types.TypeKey.Equal(*t.Key) {
+				return nil, types.TypeError{types.TypeKey, *t.Key}
+			}
+		default:
+			return nil, types.TypeError{types.TypeArray, t}
+		}
+		s.push(t)
+	case opcode.Index:
+		t := pop(unknown, types.TypeInt)
+		s.push(elem(t[0], types.ArrayT, &err))
+	case opcode.Store:
+		t := pop(unknown, unknown, unknown)
+		if err != nil {
+			break
+		}
+		switch t[0].Kind {
+		case 0:
+		case types.ArrayT:
+			if !compatible(types.TypeInt, t[1]) {
+				err = types.TypeError{types.TypeInt, t[1]}
+			} else if !compatible(*t[0].Elem, t[2]) {
+				err = types.TypeError{*t[0].Elem, t[2]}
+			}
+		case types.MapT:
+			if !compatible(*t[0].Key, t[1]) {
+				err = types.TypeError{*t[0].Key, t[1]}
+			} else if !compatible(*t[0].Elem, t[2]) {
+				err = types.TypeError{*t[0].Elem, t[2]}
+			}
+		default:
+			err = types.TypeError{types.TypeArray, t[0]}
+		}
+	case opcode.Len:
+		t := pop(unknown)[0]
+		switch t.Kind {
+		case 0, types.ArrayT, types.MapT, types.String:
+		default:
+			if err == nil {
+				err = types.TypeError{types.TypeArray, t}
+			}
+		}
+		s.push(types.TypeInt)
+	case opcode.Append:
+		t := pop(unknown, unknown)
+		if e := elem(t[0], types.ArrayT, &err); err == nil && !compatible(e, t[1]) {
+			err = types.TypeError{e, t[1]}
+		}
+		s.push(t[0])
+	case opcode.Slice:
+		t := pop(unknown, types.TypeInt, types.TypeInt)
+		elem(t[0], types.ArrayT, &err)
+		s.push(t[0])
+
+	case opcode.Lookup:
+		t := pop(unknown, unknown)
+		if t[0].Kind == types.MapT && !compatible(*t[0].Key, t[1]) && err == nil {
+			err = types.TypeError{*t[0].Key, t[1]}
+		}
+		s.push(elem(t[0], types.MapT, &err))
+		s.push(types.TypeBool)
+	case opcode.Delete:
+		t := pop(unknown, unknown)
+		elem(t[0], types.MapT, &err)
+		if t[0].Kind == types.MapT && !compatible(*t[0].Key, t[1]) && err == nil {
+			err = types.TypeError{*t[0].Key, t[1]}
+		}
+	case opcode.Keys, opcode.SKeys:
+		t := pop(unknown)[0]
+		elem(t, types.MapT, &err)
+		if t.Kind == types.MapT {
+			s.push(types.Type{types.ArrayT, types.TypeSignature{}, 0, t.Key, nil})
+		} else {
+			s.push(unknown)
+		}
+
+	case opcode.Throw:
+		pop(types.TypeErr)
+		next = nil
+	case opcode.Try:
+		// The catch label is handled by the caller
+	case opcode.EndTry:
+
+	default:
+		return nil, bytecode.InvalidOpcodeError{in.Offset, in.Op}
+	}
+	return next, err
+}
+
+// field returns the type of field n of a struct of type t, setting *err if t
+// isn't a struct or has no such field
+func (v *verifier) field(t types.Type, n int, err *error) types.Type {
+	if *err != nil || t.Kind == 0 {
+		return unknown
+	}
+	if t.Kind != types.Struct {
+		*err = types.TypeError{types.TypeStruct, t}
+		return unknown
+	}
+	if t.I < 0 || t.I >= len(v.structs) {
+		return unknown
+	}
+	fields := v.structs[t.I].Fields
+	if n < 0 || n >= len(fields) {
+		*err = types.IndexError{n, len(fields)}
+		return unknown
+	}
+	return fields[n]
+}
+
+// elem returns the element type of t, which should be an array or map of the
+// given kind, setting *err if it isn't
+func elem(t types.Type, kind types.Kind, err *error) types.Type {
+	if *err != nil || t.Kind == 0 {
+		return unknown
+	}
+	if t.Kind != kind {
+		if kind == types.MapT {
+			*err = types.TypeError{types.TypeMap, t}
+		} else {
+			*err = types.TypeError{types.TypeArray, t}
+		}
+		return unknown
+	}
+	return *t.Elem
+}
+
+// compatible returns whether a value of type got may be used where want is
+// expected. Unknown types are compatible with everything, as are unions that
+// overlap, such as int and int|float, since they are checked at runtime.
+func compatible(want, got types.Type) bool {
+	if want.Kind == 0 || got.Kind == 0 {
+		return true
+	}
+	switch {
+	case want.Kind == types.ArrayT || got.Kind == types.ArrayT:
+		return want.Kind == got.Kind && compatible(*want.Elem, *got.Elem)
+	case want.Kind == types.MapT || got.Kind == types.MapT:
+		return want.Kind == got.Kind && compatible(*want.Key, *got.Key) && compatible(*want.Elem, *got.Elem)
+	case want.Kind == types.FuncT && got.Kind == types.FuncT:
+		return compatibleTypes(want.Sig.Args, got.Sig.Args) && compatibleTypes(want.Sig.Ret, got.Sig.Ret)
+	}
+	return want.Equal(got)
+}
+
+func compatibleTypes(want, got []types.Type) bool {
+	if len(want) != len(got) {
+		return false
+	}
+	for i := range want {
+		if !compatible(want[i], got[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// same returns whether two types are identical
+func same(a, b types.Type) bool {
+	if a.Kind != b.Kind {
+		return false
+	}
+	switch a.Kind {
+	case types.Struct:
+		return a.I == b.I
+	case types.FuncT:
+		return sameTypes(a.Sig.Args, b.Sig.Args) && sameTypes(a.Sig.Ret, b.Sig.Ret)
+	case types.ArrayT:
+		return same(*a.Elem, *b.Elem)
+	case types.MapT:
+		return same(*a.Key, *b.Key) && same(*a.Elem, *b.Elem)
+	}
+	return true
+}
+
+func sameTypes(a, b []types.Type) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !same(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// join returns a type that includes both a and b. Scalar types are combined
+// into unions such as int|float, and anything else becomes unknown.
+func join(a, b types.Type) types.Type {
+	if same(a, b) {
+		return a
+	}
+	scalar := types.Int | types.Float | types.Bool | types.String
+	if a.Kind != 0 && b.Kind != 0 && (a.Kind|b.Kind)&^scalar == 0 {
+		return types.Type{a.Kind | b.Kind, types.TypeSignature{}, 0, nil, nil}
+	}
+	return unknown
+}
+
+// symbolType infers the type of a variable from its name. By convention,
+// functions are named with their type signature, such as Println:string.
+func symbolType(s types.Symbol) types.Type {
+	i := strings.IndexByte(string(s), ':')
+	if i < 0 {
+		return unknown
+	}
+	t := types.TypeFunc
+	sig := strings.TrimPrefix(string(s[i:]), ":")
+	if sig == "" {
+		return t
+	}
+	sections := codegen.Split(sig, "->")
+	t.Sig.Args = typeList(sections[0])
+	if len(sections) > 1 {
+		t.Sig.Ret = typeList(sections[1])
+	}
+	return t
+}
+
+// typeList parses a colon-separated list of types. Types that can't be
+// parsed, such as struct names which only exist in govm IR, are unknown.
+func typeList(s string) (ts []types.Type) {
+	if s == "" {
+		return
+	}
+	for _, part := range codegen.Split(s, ":") {
+		ts = append(ts, parseType(part))
+	}
+	return
+}
+
+func parseType(s string) (t types.Type) {
+	defer func() {
+		if recover() != nil {
+			t = unknown
+		}
+	}()
+	return codegen.Typ(s)
+}
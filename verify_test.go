@@ -0,0 +1,222 @@
+package govm
+
+import (
+	"./asm"
+	"./codegen"
+	"./types"
+	"./verify"
+	"errors"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestVerify(t *testing.T) {
+	for _, name := range []string{"examples/hello.gva", "examples/point.gva"} {
+		f, err := os.Open(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		g, err := asm.Assemble(f)
+		f.Close()
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		code, err := g.Generate()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := verify.File(code); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+
+	tests := []struct {
+		name string
+		gen  func(g *codegen.Generator)
+		err  error
+	}{
+		{"mod", func(g *codegen.Generator) {
+			g.Push(1)
+			g.Push("two")
+			g.Mod()
+		}, types.TypeError{types.TypeInt, types.TypeString}},
+		{"band", func(g *codegen.Generator) {
+			g.Push(1.5)
+			g.Push(2)
+			g.BAnd()
+		}, types.TypeError{types.TypeInt, types.TypeFloat}},
+		{"jt", func(g *codegen.Generator) {
+			g.Push(1)
+			g.JT(g.Label(nil))
+		}, types.TypeError{types.TypeBool, types.TypeInt}},
+		{"underflow", func(g *codegen.Generator) {
+			g.Pop()
+		}, types.StackUnderflow{}},
+		{"merge", func(g *codegen.Generator) {
+			end := new(int)
+			g.Push(true)
+			g.JT(end)
+			g.Push(1)
+			g.Label(end)
+			g.Push(2)
+		}, verify.MergeError{}},
+		{"loop", func(g *codegen.Generator) {
+			loop := g.Label(nil)
+			g.Push(1)
+			g.J(loop)
+		}, verify.MergeError{}},
+		{"result", func(g *codegen.Generator) {
+			end := new(int)
+			g.Func(codegen.Sig(":int->string"), end)
+			g.Inc()
+			g.Label(end)
+		}, verify.ResultError{}},
+		{"args", func(g *codegen.Generator) {
+			g.Push(1)
+			g.Get("Println:string")
+			g.Call()
+		}, types.TypeError{types.TypeString, types.TypeInt}},
+		{"unknown call", func(g *codegen.Generator) {
+			g.Get("f")
+			g.Call()
+		}, verify.UnknownCallError{}},
+		{"local", func(g *codegen.Generator) {
+			end := new(int)
+			g.Func(codegen.Sig(":int"), end).N = 1
+			g.LSet(1)
+			g.Label(end)
+		}, types.IndexError{1, 1}},
+	}
+	for _, test := range tests {
+		g := codegen.New()
+		test.gen(&g)
+		code, err := g.Generate()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var verr verify.Error
+		if err := verify.File(code); !errors.As(err, &verr) {
+			t.Errorf("%s: expected verify error, got %v", test.name, err)
+			continue
+		}
+		switch test.err.(type) {
+		case verify.MergeError, verify.ResultError:
+			// The stacks aren't worth comparing
+			if reflect.TypeOf(verr.Err) != reflect.TypeOf(test.err) {
+				t.Errorf("%s: expected %T, got %v", test.name, test.err, verr.Err)
+			}
+		default:
+			if !reflect.DeepEqual(verr.Err, test.err) {
+				t.Errorf("%s: expected %v, got %v", test.name, test.err, verr.Err)
+			}
+		}
+	}
+}
+
+// Programs that run without errors, but which the verifier rejects
+func TestVerifyLimits(t *testing.T) {
+	fb := fizzbuzz()
+	fb.Get("Main:")
+	fb.Call()
+	for _, test := range []struct {
+		name string
+		g    *codegen.Generator
+		err  interface{}
+	}{
+		// fizzbuzz:int->string leaves its argument below its result
+		{"extra values", &fb, new(verify.ResultError)},
+		{"unknown call", generator(func(g *codegen.Generator) {
+			g.Get("Println:string")
+			g.Set("p")
+			g.Push("hello")
+			g.Get("p")
+			g.Call()
+		}), new(verify.UnknownCallError)},
+		{"not a function", generator(func(g *codegen.Generator) {
+			g.Push(1)
+			g.Set("x:int")
+		}), new(types.TypeError)},
+	} {
+		code, err := test.g.Generate()
+		if err != nil {
+			t.Fatal(err)
+		}
+		v := New()
+		v.Stdout = io.Discard
+		if err := v.Load(code); err != nil {
+			t.Errorf("%s: %v", test.name, err)
+		}
+		if err := verify.File(code); !errors.As(err, test.err) {
+			t.Errorf("%s: expected %T, got %v", test.name, test.err, err)
+		}
+	}
+}
+
+// generator returns a Generator with the code added by body
+func generator(body func(g *codegen.Generator)) *codegen.Generator {
+	g := codegen.New()
+	body(&g)
+	return &g
+}
+
+func TestLoadVerify(t *testing.T) {
+	g := codegen.New()
+	g.Push(1)
+	g.Push("two")
+	g.Mod()
+	code, err := g.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v := New()
+	v.Verify = true
+	if err := v.Load(code); !errors.As(err, new(verify.Error)) {
+		t.Error("Expected verify error, got", err)
+	}
+	if len(v.stack) != 0 {
+		t.Error("Expected unverified code not to run, got stack", v.stack)
+	}
+
+	v.Verify = false
+	if err := v.Load(code); !errors.As(err, new(*RuntimeError)) {
+		t.Error("Expected runtime error, got", err)
+	}
+}
+
+func TestVerifySession(t *testing.T) {
+	v := New()
+	v.Verify = true
+	s := asm.NewSession()
+	run := func(src string) error {
+		g, err := s.Assemble(strings.NewReader(src))
+		if err != nil {
+			return err
+		}
+		code, err := g.Generate()
+		if err != nil {
+			return err
+		}
+		return v.Load(code)
+	}
+
+	// Later pieces are verified with the structs and stack left by earlier
+	// ones
+	for _, src := range []string{"struct P :int\n", "push 1\n", "new P\nfget 0\n"} {
+		if err := run(src); err != nil {
+			t.Fatalf("%q: %v", src, err)
+		}
+	}
+	if s := v.Stack(); len(s) != 1 || s[0] != 1 {
+		t.Error("Expected stack [1], got", s)
+	}
+	if err := run("push \"x\"\nadd\n"); !errors.As(err, new(verify.Error)) {
+		t.Error("Expected verify error, got", err)
+	}
+	if err := run("pop\npop\n"); !errors.As(err, new(types.StackUnderflow)) {
+		t.Error("Expected stack underflow, got", err)
+	}
+}
@@ -1,21 +1,95 @@
 package govm
 
 import (
+	"context"
+	"errors"
 	"io"
+	"os"
+	"sort"
 	"./bytecode"
 	"./opcode"
 	"./stdlib"
 	"./types"
+	"./verify"
 )
 
 type VM struct {
-	stack types.Stack
-	scope *types.Scope
-	code  *bytecode.Reader
+	stack    types.Stack
+	scope    *types.Scope
+	code     []types.Instruction
+	pc       int               // Index of the next instruction in code
+	locals   []types.Value     // Local variable slots of the current function
+	fn       *types.Function   // Function being executed, or nil at the top level
+	handlers []handler         // Active try regions in the current frame, innermost last
+	structs  []types.StructDef // Struct table, indexed by types.Type.I
+	debug    *types.DebugInfo  // Debug info of the code being loaded, if any
+
+	// Budget is the maximum number of instructions that may be executed by
+	// each Load, LoadFrom or Call made from outside the VM. 0 means no limit.
+	Budget int
+
+	// MaxDepth and MaxStack limit the number of nested function calls and
+	// the number of values on the stack. 0 means no limit.
+	MaxDepth int
+	MaxStack int
+	depth    int
+
+	// Verify makes Load refuse code that fails static verification. Some
+	// valid programs are refused, as described in package verify.
+	Verify bool
+
+	// Hook, if set, is called before each instruction is executed. It may
+	// inspect the VM with Location, Stack and Scope, and if it returns an
+	// error, execution stops with that error.
+	Hook func(v *VM) error
+
+	// Trace, if set, is called with each instruction before it is executed
+	Trace func(e TraceEvent)
+
+	// Profile, if set, records the instructions executed and the time spent
+	// in each function
+	Profile *Profile
+
+	// Coverage, if set, records the instructions executed in code loaded
+	// after it was set
+	Coverage *Coverage
+
+	// Stdin, Stdout and Stderr are the streams used by builtins for I/O.
+	// nil means os.Stdin, os.Stdout and os.Stderr.
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+
+	running bool            // Whether a Load, LoadFrom or Call is in progress
+	steps   int             // Instructions executed since running was set
+	ctx     context.Context // Context passed to CallContext, if any
 }
 
+const (
+	DefaultMaxDepth = 10000
+	DefaultMaxStack = 1 << 20
+)
+
 func NewWithoutStdlib() (v VM) {
 	v.scope = &types.Scope{}
+	v.MaxDepth = DefaultMaxDepth
+	v.MaxStack = DefaultMaxStack
+	return
+}
+
+// Streams returns the VM's Stdin, Stdout and Stderr, or those in package os
+// for any that aren't set
+func (v *VM) Streams() (stdin io.Reader, stdout, stderr io.Writer) {
+	stdin, stdout, stderr = v.Stdin, v.Stdout, v.Stderr
+	if stdin == nil {
+		stdin = os.Stdin
+	}
+	if stdout == nil {
+		stdout = os.Stdout
+	}
+	if stderr == nil {
+		stderr = os.Stderr
+	}
 	return
 }
 
@@ -29,76 +103,226 @@ func New() (v VM) {
 }
 
 func (v *VM) LoadFrom(r io.ReadSeeker) error {
-	v.code = &bytecode.Reader{r}
-	return v.exec()
+	code, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	return v.Load(code)
+}
+
+// Load runs a GVB file. Files without a header are loaded in legacy mode, as
+// a code section with no other sections.
+func (v *VM) Load(data []byte) error {
+	f, err := bytecode.ParseFile(data)
+	if err != nil {
+		return err
+	}
+	defs, err := bytecode.ReadStructs(f)
+	if err != nil {
+		return err
+	}
+	structs, index := bytecode.LinkStructs(v.structs, defs)
+	instrs, debug, err := bytecode.DecodeFile(f, index)
+	if err != nil {
+		return err
+	}
+	if v.Verify {
+		stack := make([]types.Type, len(v.stack))
+		for i, val := range v.stack {
+			stack[i] = types.TypeOf(val)
+		}
+		if err := verify.Code(instrs, structs, stack); err != nil {
+			return err
+		}
+	}
+	v.structs = structs
+	if v.Coverage != nil {
+		v.Coverage.add("<toplevel>", instrs, debug)
+	}
+	return v.top(len(v.stack), func() error {
+		code, pc, fn, locals, d := v.code, v.pc, v.fn, v.locals, v.debug
+		handlers := v.handlers
+		defer func() {
+			v.code, v.pc = code, pc
+			v.fn = fn
+			v.locals = locals
+			v.debug = d
+			v.handlers = handlers
+		}()
+		v.code, v.pc = instrs, 0
+		v.fn = nil
+		v.locals = nil
+		v.debug = debug
+		v.handlers = nil
+		return v.exec()
+	})
 }
 
-func (v *VM) Load(code []byte) error {
-	v.code = bytecode.NewSliceReader(code)
-	return v.exec()
+// top runs f as an entry point into the VM from outside it, resetting the
+// instruction count. If f is interrupted by the budget, the context or a stack
+// overflow, the stack is truncated to at most keep values, discarding any partial results.
+func (v *VM) top(keep int, f func() error) error {
+	if v.running {
+		// Re-entered from a builtin
+		return f()
+	}
+	v.running = true
+	v.steps = 0
+	err := f()
+	v.running = false
+	if v.Profile != nil {
+		v.Profile.stop()
+	}
+
+	if errors.Is(err, ErrBudgetExhausted) || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) ||
+		errors.As(err, new(types.StackOverflow)) {
+		if keep < 0 {
+			keep = 0
+		}
+		if len(v.stack) > keep {
+			v.stack = v.stack[:keep]
+		}
+		v.handlers = nil
+	}
+	return err
+}
+
+// step is called before each instruction when there is a budget or context
+func (v *VM) step() error {
+	v.steps++
+	if v.Budget > 0 && v.steps > v.Budget {
+		return ErrBudgetExhausted
+	}
+	// Checking the context is relatively slow, so only do it occasionally
+	if v.ctx != nil && v.steps%1024 == 0 {
+		return v.ctx.Err()
+	}
+	return nil
+}
+
+// A handler is an active try region
+type handler struct {
+	catch int          // Index of the instruction to jump to when an error is caught
+	depth int          // Size of the stack when the region was entered
+	scope *types.Scope // Scope when the region was entered
 }
 
+// exec runs the current code, jumping to the innermost handler when an Error
+// is thrown and adding the current frame to the trace of any other error
 func (v *VM) exec() error {
+	err := v.run()
+	for len(v.handlers) > 0 && err != nil && err != types.Return {
+		var e types.Error
+		if !errors.As(err, &e) {
+			break
+		}
+		h := v.handlers[len(v.handlers)-1]
+		v.handlers = v.handlers[:len(v.handlers)-1]
+		if len(v.stack) > h.depth {
+			v.stack = v.stack[:h.depth]
+		}
+		v.scope = h.scope
+		v.pc = h.catch
+		v.Push(e)
+		err = v.run()
+	}
+	if err == nil || err == types.Return {
+		return err
+	}
+	frame := Frame{v.fn, 0, types.Pos{}}
+	if v.pc > 0 {
+		frame.Offset = v.code[v.pc-1].Offset
+		frame.Pos, _ = v.debugInfo().Lookup(frame.Offset)
+	}
+	if rerr, ok := err.(*RuntimeError); ok {
+		rerr.Trace = append(rerr.Trace, frame)
+		return rerr
+	}
+	return &RuntimeError{err, []Frame{frame}}
+}
+
+// debugInfo returns the debug info of the current code, if any
+func (v *VM) debugInfo() *types.DebugInfo {
+	if v.fn != nil {
+		return v.fn.Debug
+	}
+	return v.debug
+}
+
+func (v *VM) run() error {
 	for {
-		op, err := v.code.ReadByte()
-		if err == io.EOF {
+		if v.Budget > 0 || v.ctx != nil {
+			if err := v.step(); err != nil {
+				return err
+			}
+		}
+		if v.MaxStack > 0 && len(v.stack) > v.MaxStack {
+			return types.StackOverflow{}
+		}
+		if v.pc >= len(v.code) {
 			return nil
-		} else if err != nil {
-			return err
 		}
-
-		switch op {
-		case opcode.J:
-			off, err := v.code.Int()
-			if err != nil {
+		if v.Hook != nil {
+			if err := v.Hook(v); err != nil {
 				return err
 			}
-			if err := v.Jump(off); err != nil {
+		}
+		in := &v.code[v.pc]
+		if v.Profile != nil {
+			v.Profile.instr(v, in.Offset)
+		}
+		if v.Trace != nil {
+			v.trace(in)
+		}
+		if v.Coverage != nil {
+			v.Coverage.hit(in)
+		}
+		v.pc++
+
+		switch in.Op {
+		case opcode.J:
+			if err := v.Jump(in.Arg); err != nil {
 				return err
 			}
 
 		case opcode.JT:
-			off, err := v.code.Int()
-			if err != nil {
+			taken := v.Coverage != nil && v.taken(in.Op)
+			if err := v.JumpTrue(in.Arg); err != nil {
 				return err
 			}
-			if err := v.JumpTrue(off); err != nil {
-				return err
+			if v.Coverage != nil {
+				v.Coverage.branch(in, taken)
 			}
 
 		case opcode.JF:
-			off, err := v.code.Int()
-			if err != nil {
+			taken := v.Coverage != nil && v.taken(in.Op)
+			if err := v.JumpFalse(in.Arg); err != nil {
 				return err
 			}
-			if err := v.JumpFalse(off); err != nil {
-				return err
+			if v.Coverage != nil {
+				v.Coverage.branch(in, taken)
 			}
 
 		case opcode.JZ:
-			off, err := v.code.Int()
-			if err != nil {
+			taken := v.Coverage != nil && v.taken(in.Op)
+			if err := v.JumpZero(in.Arg); err != nil {
 				return err
 			}
-			if err := v.JumpZero(off); err != nil {
-				return err
+			if v.Coverage != nil {
+				v.Coverage.branch(in, taken)
 			}
 
 		case opcode.JNz:
-			off, err := v.code.Int()
-			if err != nil {
+			taken := v.Coverage != nil && v.taken(in.Op)
+			if err := v.JumpNonzero(in.Arg); err != nil {
 				return err
 			}
-			if err := v.JumpNonzero(off); err != nil {
-				return err
+			if v.Coverage != nil {
+				v.Coverage.branch(in, taken)
 			}
 
 		case opcode.Push:
-			val, err := v.code.TypedValue()
-			if err != nil {
-				return err
-			}
-			v.Push(val)
+			v.Push(in.Val)
 
 		case opcode.Pop:
 			if _, err := v.Pop(); err != nil {
@@ -116,18 +340,20 @@ func (v *VM) exec() error {
 			}
 
 		case opcode.Set:
-			s, err := v.code.String()
-			if err != nil {
+			if err := v.Set(in.Val.(types.Symbol)); err != nil {
 				return err
 			}
-			v.Set(types.Symbol(s))
 
 		case opcode.Get:
-			s, err := v.code.String()
-			if err != nil {
+			if err := v.Get(in.Val.(types.Symbol)); err != nil {
 				return err
 			}
-			if err := v.Get(types.Symbol(s)); err != nil {
+		case opcode.LSet:
+			if err := v.LSet(in.Arg); err != nil {
+				return err
+			}
+		case opcode.LGet:
+			if err := v.LGet(in.Arg); err != nil {
 				return err
 			}
 
@@ -244,7 +470,7 @@ func (v *VM) exec() error {
 			}
 
 		case opcode.Call:
-			if err := v.Call(); err != nil {
+			if err := v.call(); err != nil {
 				return err
 			}
 
@@ -253,25 +479,112 @@ func (v *VM) exec() error {
 			return types.Return
 
 		case opcode.Func:
-			sig, err := v.code.TypeSignature()
-			code, err := v.code.Bytes()
-			if err != nil {
+			f := in.Val.(types.Function)
+			f.Env = v.scope
+			v.Push(f)
+
+		case opcode.New:
+			if err := v.New(in.Arg); err != nil {
+				return err
+			}
+
+		case opcode.FGet:
+			if err := v.FGet(in.Arg); err != nil {
+				return err
+			}
+
+		case opcode.FSet:
+			if err := v.FSet(in.Arg); err != nil {
+				return err
+			}
+
+		case opcode.Make:
+			if err := v.Make(in.Val.(types.Type)); err != nil {
+				return err
+			}
+
+		case opcode.Index:
+			if err := v.Index(); err != nil {
+				return err
+			}
+		case opcode.Store:
+			if err := v.Store(); err != nil {
+				return err
+			}
+		case opcode.Len:
+			if err := v.Len(); err != nil {
+				return err
+			}
+		case opcode.Append:
+			if err := v.Append(); err != nil {
+				return err
+			}
+		case opcode.Slice:
+			if err := v.Slice(); err != nil {
+				return err
+			}
+
+		case opcode.Lookup:
+			if err := v.Lookup(); err != nil {
+				return err
+			}
+		case opcode.Delete:
+			if err := v.Delete(); err != nil {
+				return err
+			}
+		case opcode.Keys:
+			if err := v.Keys(); err != nil {
+				return err
+			}
+		case opcode.SKeys:
+			if err := v.SKeys(); err != nil {
+				return err
+			}
+
+		case opcode.Throw:
+			return v.Throw()
+		case opcode.Try:
+			v.Try(in.Arg)
+		case opcode.EndTry:
+			if err := v.EndTry(); err != nil {
 				return err
 			}
-			v.Func(sig, code)
 
 		default:
-			panic("Unknown opcode")
+			return InvalidOpcodeError{in.Offset, in.Op}
 		}
 	}
 }
 
-func (v *VM) Jump(off int) error {
-	_, err := v.code.Seek(int64(off), io.SeekCurrent)
-	return err
+// Jump continues execution at the instruction with the given index in the
+// current code
+func (v *VM) Jump(target int) error {
+	v.pc = target
+	return nil
+}
+
+// taken returns whether a conditional jump with opcode op will be taken,
+// given the value on top of the stack. The pc can't be used to tell, as the
+// target may be the next instruction.
+func (v *VM) taken(op byte) bool {
+	val, err := v.stack.Peek(0)
+	if err != nil {
+		return false
+	}
+	switch op {
+	case opcode.JT:
+		return val == true
+	case opcode.JF:
+		return val == false
+	case opcode.JZ:
+		return val == 0 || val == 0.0
+	case opcode.JNz:
+		return val != 0 && val != 0.0
+	}
+	return false
 }
 
-func (v *VM) JumpTrue(off int) error {
+func (v *VM) JumpTrue(target int) error {
 	val, err := v.Pop()
 	if err != nil {
 		return err
@@ -279,7 +592,7 @@ func (v *VM) JumpTrue(off int) error {
 	switch val := val.(type) {
 	case bool:
 		if val {
-			return v.Jump(off)
+			return v.Jump(target)
 		}
 	default:
 		return types.TypeError{types.TypeBool, types.TypeOf(val)}
@@ -287,7 +600,7 @@ func (v *VM) JumpTrue(off int) error {
 	return nil
 }
 
-func (v *VM) JumpFalse(off int) error {
+func (v *VM) JumpFalse(target int) error {
 	val, err := v.Pop()
 	if err != nil {
 		return err
@@ -295,7 +608,7 @@ func (v *VM) JumpFalse(off int) error {
 	switch val := val.(type) {
 	case bool:
 		if !val {
-			return v.Jump(off)
+			return v.Jump(target)
 		}
 	default:
 		return types.TypeError{types.TypeBool, types.TypeOf(val)}
@@ -303,7 +616,7 @@ func (v *VM) JumpFalse(off int) error {
 	return nil
 }
 
-func (v *VM) JumpZero(off int) error {
+func (v *VM) JumpZero(target int) error {
 	val, err := v.Pop()
 	if err != nil {
 		return err
@@ -311,11 +624,11 @@ func (v *VM) JumpZero(off int) error {
 	switch val := val.(type) {
 	case int:
 		if val == 0 {
-			return v.Jump(off)
+			return v.Jump(target)
 		}
 	case float64:
 		if val == 0.0 {
-			return v.Jump(off)
+			return v.Jump(target)
 		}
 	default:
 		return types.TypeError{types.TypeBool, types.TypeOf(val)}
@@ -323,7 +636,7 @@ func (v *VM) JumpZero(off int) error {
 	return nil
 }
 
-func (v *VM) JumpNonzero(off int) error {
+func (v *VM) JumpNonzero(target int) error {
 	val, err := v.Pop()
 	if err != nil {
 		return err
@@ -331,11 +644,11 @@ func (v *VM) JumpNonzero(off int) error {
 	switch val := val.(type) {
 	case int:
 		if val != 0 {
-			return v.Jump(off)
+			return v.Jump(target)
 		}
 	case float64:
 		if val != 0.0 {
-			return v.Jump(off)
+			return v.Jump(target)
 		}
 	default:
 		return types.TypeError{types.TypeBool, types.TypeOf(val)}
@@ -377,6 +690,31 @@ func (v *VM) Get(s types.Symbol) error {
 	return nil
 }
 
+// LSet pops a value into local variable slot n of the current function
+func (v *VM) LSet(n int) error {
+	if n < 0 || n >= len(v.locals) {
+		return types.IndexError{n, len(v.locals)}
+	}
+	val, err := v.Pop()
+	if err != nil {
+		return err
+	}
+	v.locals[n] = val
+	return nil
+}
+
+// LGet pushes the value of local variable slot n of the current function
+func (v *VM) LGet(n int) error {
+	if n < 0 || n >= len(v.locals) {
+		return types.IndexError{n, len(v.locals)}
+	}
+	if v.locals[n] == nil {
+		return types.LocalError{n}
+	}
+	v.Push(v.locals[n])
+	return nil
+}
+
 func (v *VM) Inc() error {
 	val, err := v.Pop()
 	if err != nil {
@@ -1061,7 +1399,8 @@ func (v *VM) BMtch() error {
 
 func (v *VM) checkTypes(types []types.Type) error {
 	for i, t := range types {
-		val, err := v.stack.Peek(i)
+		// The last type is at the top of the stack
+		val, err := v.stack.Peek(len(types) - 1 - i)
 		if err != nil {
 			return err
 		}
@@ -1072,7 +1411,28 @@ func (v *VM) checkTypes(types []types.Type) error {
 	return nil
 }
 
+// CallContext is like Call, but stops execution with the context's error
+// when the context is cancelled or its deadline passes
+func (v *VM) CallContext(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	ctx0 := v.ctx
+	v.ctx = ctx
+	defer func() { v.ctx = ctx0 }()
+	return v.Call()
+}
+
 func (v *VM) Call() error {
+	// If the call is interrupted, the function and its arguments are consumed
+	keep := len(v.stack) - 1
+	if f, err := v.stack.Peek(0); err == nil {
+		keep -= len(types.TypeOf(f).Sig.Args)
+	}
+	return v.top(keep, v.call)
+}
+
+func (v *VM) call() error {
 	f, err := v.Pop()
 	if err != nil {
 		return err
@@ -1084,12 +1444,36 @@ func (v *VM) Call() error {
 			return err
 		}
 
-		v.scope = v.scope.Child()
-		code := v.code
-		v.code = bytecode.NewSliceReader(f.Code)
+		if v.MaxDepth > 0 && v.depth >= v.MaxDepth {
+			return types.StackOverflow{true}
+		}
+
+		scope, code, pc, fn, locals := v.scope, v.code, v.pc, v.fn, v.locals
+		if f.Env != nil {
+			v.scope = f.Env.Child()
+		} else {
+			v.scope = v.scope.Child()
+		}
+		handlers := v.handlers
+		prof := v.Profile
+		if prof != nil {
+			prof.enter(v, f)
+		}
+		v.depth++
+		v.code, v.pc = f.Code, 0
+		v.fn = &f
+		v.locals = make([]types.Value, f.Locals)
+		v.handlers = nil
 		defer func() {
-			v.code = code
-			v.scope = v.scope.Parent
+			v.code, v.pc = code, pc
+			v.scope = scope
+			v.fn = fn
+			v.locals = locals
+			v.handlers = handlers
+			v.depth--
+			if prof != nil {
+				prof.exit()
+			}
 		}()
 		if err := v.exec(); err != types.Return && err != nil {
 			return err
@@ -1102,11 +1486,28 @@ func (v *VM) Call() error {
 		if err := v.checkTypes(f.Sig.Args); err != nil {
 			return err
 		}
-		args, err := v.stack.PopN(len(f.Sig.Args))
+		// The args are left on the stack until the builtin returns, so that
+		// functions it calls don't overwrite them
+		base := len(v.stack) - len(f.Sig.Args)
+		args := v.stack[base:len(v.stack):len(v.stack)]
+		prof := v.Profile
+		if prof != nil {
+			prof.enter(v, f)
+		}
+		rets, err := f.F(v, args...)
+		if prof != nil {
+			prof.exit()
+		}
+		v.stack = v.stack[:base]
 		if err != nil {
+			// Errors from functions called by the builtin are passed on as
+			// they are, so that errors thrown by them can be caught and other
+			// errors can't
+			if _, ok := err.(types.Error); !ok && !errors.As(err, new(*RuntimeError)) {
+				err = types.Error{err}
+			}
 			return err
 		}
-		rets := f.F(args...)
 		v.stack = append(v.stack, rets...)
 		if err := v.checkTypes(f.Sig.Ret); err != nil {
 			return err
@@ -1118,10 +1519,362 @@ func (v *VM) Call() error {
 	return nil
 }
 
-func (v *VM) Func(sig types.TypeSignature, code []byte) {
-	v.Push(types.Function{sig, code})
+func (v *VM) Func(sig types.TypeSignature, locals int, code []types.Instruction) {
+	v.Push(types.Function{sig, locals, code, v.scope, nil})
+}
+
+func (v *VM) Builtin(sig types.TypeSignature, f func(types.Caller, ...types.Value) ([]types.Value, error)) {
+	v.Push(types.NewBuiltin(sig, f))
+}
+
+func (v *VM) New(i int) error {
+	if i < 0 || i >= len(v.structs) {
+		return types.IndexError{i, len(v.structs)}
+	}
+	fields := v.structs[i].Fields
+	vals, err := v.stack.PopN(len(fields))
+	if err != nil {
+		return err
+	}
+	for j, t := range fields {
+		if err := t.TypeCheck(vals[j]); err != nil {
+			return err
+		}
+	}
+	// PopN returns a slice of the stack, which will be overwritten by later pushes
+	v.Push(types.StructValue{i, append([]types.Value(nil), vals...)})
+	return nil
+}
+
+func (v *VM) popStruct() (types.StructValue, error) {
+	val, err := v.Pop()
+	if err != nil {
+		return types.StructValue{}, err
+	}
+	s, ok := val.(types.StructValue)
+	if !ok {
+		return types.StructValue{}, types.TypeError{types.TypeStruct, types.TypeOf(val)}
+	}
+	return s, nil
+}
+
+func (v *VM) FGet(n int) error {
+	s, err := v.popStruct()
+	if err != nil {
+		return err
+	}
+	if n < 0 || n >= len(s.Fields) {
+		return types.IndexError{n, len(s.Fields)}
+	}
+	v.Push(s.Fields[n])
+	return nil
+}
+
+func (v *VM) FSet(n int) error {
+	val, err := v.Pop()
+	if err != nil {
+		return err
+	}
+	s, err := v.popStruct()
+	if err != nil {
+		return err
+	}
+	if n < 0 || n >= len(s.Fields) {
+		return types.IndexError{n, len(s.Fields)}
+	}
+	if err := v.structs[s.I].Fields[n].TypeCheck(val); err != nil {
+		return err
+	}
+	s.Fields[n] = val
+	return nil
+}
+
+func (v *VM) Make(t types.Type) error {
+	switch t.Kind {
+	case types.ArrayT:
+		n, err := v.Pop()
+		if err != nil {
+			return err
+		}
+		if err := types.TypeInt.TypeCheck(n); err != nil {
+			return err
+		}
+		if n.(int) < 0 {
+			return types.IndexError{n.(int), 0}
+		}
+		arr := types.Array{*t.Elem, make([]types.Value, n.(int))}
+		for i := range arr.V {
+			zero, ok := t.Elem.Zero()
+			if !ok {
+				return types.ZeroError{*t.Elem}
+			}
+			arr.V[i] = zero
+		}
+		v.Push(arr)
+		return nil
+	case types.MapT:
+		if !types.TypeKey.Equal(*t.Key) {
+			return types.TypeError{types.TypeKey, *t.Key}
+		}
+		m, _ := t.Zero()
+		v.Push(m)
+		return nil
+	default:
+		return types.TypeError{types.TypeArray, t}
+	}
+}
+
+func (v *VM) popArray() (types.Array, error) {
+	val, err := v.Pop()
+	if err != nil {
+		return types.Array{}, err
+	}
+	arr, ok := val.(types.Array)
+	if !ok {
+		return types.Array{}, types.TypeError{types.TypeArray, types.TypeOf(val)}
+	}
+	return arr, nil
+}
+
+func checkIndex(i types.Value, n int) (int, error) {
+	if err := types.TypeInt.TypeCheck(i); err != nil {
+		return 0, err
+	}
+	if i.(int) < 0 || i.(int) >= n {
+		return 0, types.IndexError{i.(int), n}
+	}
+	return i.(int), nil
+}
+
+func (v *VM) Index() error {
+	i, err := v.Pop()
+	if err != nil {
+		return err
+	}
+	arr, err := v.popArray()
+	if err != nil {
+		return err
+	}
+	idx, err := checkIndex(i, len(arr.V))
+	if err != nil {
+		return err
+	}
+	v.Push(arr.V[idx])
+	return nil
+}
+
+func (v *VM) Store() error {
+	val, err := v.Pop()
+	if err != nil {
+		return err
+	}
+	i, err := v.Pop()
+	if err != nil {
+		return err
+	}
+	c, err := v.Pop()
+	if err != nil {
+		return err
+	}
+
+	switch c := c.(type) {
+	case types.Array:
+		idx, err := checkIndex(i, len(c.V))
+		if err != nil {
+			return err
+		}
+		if err := c.Elem.TypeCheck(val); err != nil {
+			return err
+		}
+		c.V[idx] = val
+	case types.Map:
+		if err := c.Key.TypeCheck(i); err != nil {
+			return err
+		}
+		if err := c.Elem.TypeCheck(val); err != nil {
+			return err
+		}
+		c.M[i] = val
+	default:
+		return types.TypeError{types.TypeArray, types.TypeOf(c)}
+	}
+	return nil
+}
+
+func (v *VM) Len() error {
+	val, err := v.Pop()
+	if err != nil {
+		return err
+	}
+	switch val := val.(type) {
+	case types.Array:
+		v.Push(len(val.V))
+	case types.Map:
+		v.Push(len(val.M))
+	case string:
+		v.Push(len(val))
+	default:
+		return types.TypeError{types.TypeArray, types.TypeOf(val)}
+	}
+	return nil
+}
+
+func (v *VM) Append() error {
+	val, err := v.Pop()
+	if err != nil {
+		return err
+	}
+	arr, err := v.popArray()
+	if err != nil {
+		return err
+	}
+	if err := arr.Elem.TypeCheck(val); err != nil {
+		return err
+	}
+	arr.V = append(arr.V, val)
+	v.Push(arr)
+	return nil
+}
+
+func (v *VM) Slice() error {
+	hi, err := v.Pop()
+	if err != nil {
+		return err
+	}
+	lo, err := v.Pop()
+	if err != nil {
+		return err
+	}
+	arr, err := v.popArray()
+	if err != nil {
+		return err
+	}
+	if err := types.TypeInt.TypeCheck(lo); err != nil {
+		return err
+	}
+	if err := types.TypeInt.TypeCheck(hi); err != nil {
+		return err
+	}
+	if hi.(int) < 0 || hi.(int) > len(arr.V) {
+		return types.IndexError{hi.(int), len(arr.V)}
+	}
+	if lo.(int) < 0 || lo.(int) > hi.(int) {
+		return types.IndexError{lo.(int), hi.(int)}
+	}
+	arr.V = arr.V[lo.(int):hi.(int)]
+	v.Push(arr)
+	return nil
+}
+
+func (v *VM) popMap() (types.Map, error) {
+	val, err := v.Pop()
+	if err != nil {
+		return types.Map{}, err
+	}
+	m, ok := val.(types.Map)
+	if !ok {
+		return types.Map{}, types.TypeError{types.TypeMap, types.TypeOf(val)}
+	}
+	return m, nil
+}
+
+func (v *VM) Lookup() error {
+	k, err := v.Pop()
+	if err != nil {
+		return err
+	}
+	m, err := v.popMap()
+	if err != nil {
+		return err
+	}
+	if err := m.Key.TypeCheck(k); err != nil {
+		return err
+	}
+	val, ok := m.M[k]
+	if !ok {
+		if val, ok = m.Elem.Zero(); !ok {
+			return types.ZeroError{m.Elem}
+		}
+		ok = false
+	}
+	v.Push(val)
+	v.Push(ok)
+	return nil
+}
+
+func (v *VM) Delete() error {
+	k, err := v.Pop()
+	if err != nil {
+		return err
+	}
+	m, err := v.popMap()
+	if err != nil {
+		return err
+	}
+	if err := m.Key.TypeCheck(k); err != nil {
+		return err
+	}
+	delete(m.M, k)
+	return nil
+}
+
+func (v *VM) Keys() error {
+	m, err := v.popMap()
+	if err != nil {
+		return err
+	}
+	keys := make([]types.Value, 0, len(m.M))
+	for k := range m.M {
+		keys = append(keys, k)
+	}
+	v.Push(types.Array{m.Key, keys})
+	return nil
+}
+
+func (v *VM) SKeys() error {
+	if err := v.Keys(); err != nil {
+		return err
+	}
+	keys := v.stack[len(v.stack)-1].(types.Array).V
+	sort.Slice(keys, func(i, j int) bool {
+		switch a := keys[i].(type) {
+		case int:
+			return a < keys[j].(int)
+		case float64:
+			return a < keys[j].(float64)
+		case string:
+			return a < keys[j].(string)
+		case bool:
+			return !a && keys[j].(bool)
+		}
+		return false
+	})
+	return nil
 }
 
-func (v *VM) Builtin(sig types.TypeSignature, f func(...types.Value) []types.Value) {
-	v.Push(types.Builtin{sig, f})
+// Throw pops an error and returns it, to be caught by the innermost try region
+func (v *VM) Throw() error {
+	e, err := v.Pop()
+	if err != nil {
+		return err
+	}
+	if err := types.TypeErr.TypeCheck(e); err != nil {
+		return err
+	}
+	return e.(types.Error)
+}
+
+// Try enters a try region. Errors thrown before the matching EndTry cause a
+// jump to the catch offset, with the stack and scope restored and the error
+// pushed onto the stack
+func (v *VM) Try(catch int) {
+	v.handlers = append(v.handlers, handler{catch, len(v.stack), v.scope})
+}
+
+func (v *VM) EndTry() error {
+	if len(v.handlers) == 0 {
+		return NoTryError{}
+	}
+	v.handlers = v.handlers[:len(v.handlers)-1]
+	return nil
 }